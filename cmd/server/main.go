@@ -10,6 +10,7 @@ import (
 	"github.com/mart123p/ctf-reverseproxy/internal/services/http/reverseproxy"
 	"github.com/mart123p/ctf-reverseproxy/internal/services/metrics"
 	"github.com/mart123p/ctf-reverseproxy/internal/services/sessionmanager"
+	"github.com/mart123p/ctf-reverseproxy/internal/services/webhook"
 	"github.com/mart123p/ctf-reverseproxy/pkg/cbroadcast"
 	"github.com/mart123p/ctf-reverseproxy/pkg/graceful"
 )
@@ -32,6 +33,7 @@ func registerServices() {
 	service.Add(&docker.DockerService{})
 	service.Add(&sessionmanager.SessionManagerService{})
 	service.Add(&metrics.MetricsService{})
+	service.Add(&webhook.WebhookService{})
 	service.Add(&mgmt.MgmtServer{})
 	service.Add(&reverseproxy.ReverseProxy{})
 }
@@ -32,13 +32,88 @@ func setupDefault() {
 	viper.SetDefault(CReverseProxyHost, "")
 	viper.SetDefault(CReverseProxyPort, "8000")
 	viper.SetDefault(CReverseProxySessionHeader, "X-Session-Id")
+	viper.SetDefault(CReverseProxySessionSaltFile, "")
+	viper.SetDefault(CReverseProxySessionKeyringFile, "")
+	_ = viper.BindEnv(CReverseProxySessionSalt, "CTF_REVERSEPROXY_SESSION_SALT")
 	viper.SetDefault(CReverseProxySessionTimeout, "300")
+	viper.SetDefault(CReverseProxySessionMaxLifetime, "0")
+	viper.SetDefault(CReverseProxySessionWarning, "60")
+	viper.SetDefault(CReverseProxySessionBanner, false)
 	viper.SetDefault(CReverseProxyPool, "5")
+	viper.SetDefault(CReverseProxySharedEnabled, false)
+	viper.SetDefault(CReverseProxySharedStrategy, "least-sessions")
+	viper.SetDefault(CReverseProxySharedSessionsPerInstance, "10")
+	viper.SetDefault(CReverseProxySharedMaxInstances, "0")
+	viper.SetDefault(CReverseProxySessionTeamsFile, "")
+	viper.SetDefault(CReverseProxySessionSuspend, "0")
+	viper.SetDefault(CReverseProxySessionReservationsFile, "")
+	viper.SetDefault(CReverseProxyQueueAging, "10")
+
+	viper.SetDefault(CReverseProxyHeadersForwarded, true)
+	viper.SetDefault(CReverseProxyHeadersInstance, "")
+	viper.SetDefault(CReverseProxyHeadersResponseRewrite, true)
+
+	viper.SetDefault(CReverseProxyAccessLogFile, "")
+	viper.SetDefault(CReverseProxyAccessLogMaxSize, "100")
+	viper.SetDefault(CReverseProxyAccessLogMaxBackups, "5")
+
+	viper.SetDefault(CReverseProxyCaptureDir, "")
+	viper.SetDefault(CReverseProxyCaptureBodySize, "65536")
+	viper.SetDefault(CReverseProxyCaptureRedact, []string{"Authorization", "Cookie", "Set-Cookie"})
+	viper.SetDefault(CReverseProxyCaptureMaxSize, "50")
+	viper.SetDefault(CReverseProxyCaptureMaxFiles, "10")
+
+	viper.SetDefault(CReverseProxyErrorPage, "")
+	viper.SetDefault(CReverseProxyErrorThreshold, "3")
+
+	viper.SetDefault(CReverseProxyRateLimitIPRate, "0")
+	viper.SetDefault(CReverseProxyRateLimitIPBurst, "0")
+	viper.SetDefault(CReverseProxyRateLimitSessionRate, "0")
+	viper.SetDefault(CReverseProxyRateLimitSessionBurst, "0")
+	viper.SetDefault(CReverseProxyRateLimitBody, "0")
+	viper.SetDefault(CReverseProxyRateLimitBandwidth, "0")
+
+	viper.SetDefault(CReverseProxyPowDifficulty, "0")
+
+	viper.SetDefault(CReverseProxyAuthType, "")
+	viper.SetDefault(CReverseProxyAuthJWTAlgorithm, "HS256")
+	viper.SetDefault(CReverseProxyAuthJWTClaim, "team_id")
+	viper.SetDefault(CReverseProxyAuthJWTTeamClaim, "")
+	viper.SetDefault(CReverseProxyAuthJWTPriorityClaim, "")
+	viper.SetDefault(CReverseProxyAuthHTTPHeader, "Authorization")
+	viper.SetDefault(CReverseProxyAuthHTTPScheme, "Token")
+	viper.SetDefault(CReverseProxyAuthHTTPField, "team_id")
+	viper.SetDefault(CReverseProxyAuthHTTPPriorityField, "")
+	viper.SetDefault(CReverseProxyAuthHTTPCache, "300")
+	viper.SetDefault(CReverseProxyAuthHTTPTimeout, "5")
 
 	viper.SetDefault(CMgmtHost, "")
 	viper.SetDefault(CMgmtPort, "8080")
+	viper.SetDefault(CMgmtKey, "")
+	viper.SetDefault(CMgmtAuthFailures, "5")
+	viper.SetDefault(CMgmtAuthLockout, "60")
+	viper.SetDefault(CMgmtAuditFile, "")
+	viper.SetDefault(CMgmtAuditMaxSize, "0")
+	viper.SetDefault(CMgmtAuditMaxBackups, "-1")
+
+	viper.SetDefault(CWebhookUrls, []string{})
+	viper.SetDefault(CWebhookSecret, "")
+	viper.SetDefault(CWebhookEvents, []string{})
+	viper.SetDefault(CWebhookSessionId, false)
+	viper.SetDefault(CWebhookRetries, "3")
+	viper.SetDefault(CWebhookQueue, "100")
+	viper.SetDefault(CWebhookTimeout, "5")
 
 	viper.SetDefault(CDockerHost, "unix:///var/run/docker.sock")
+	viper.SetDefault(CDockerSuspendMode, "pause")
+	viper.SetDefault(CDockerLogsArchive, "")
+	viper.SetDefault(CDockerLogsArchiveTail, "10000")
+	viper.SetDefault(CDockerSnapshotDir, "")
+	viper.SetDefault(CDockerSnapshotMode, "diff")
+	viper.SetDefault(CDockerSnapshotMaxSize, "100")
+	viper.SetDefault(CDockerSnapshotImage, "ctf-snapshot")
+	viper.SetDefault(CDockerSnapshotMaxAge, "0")
+	viper.SetDefault(CDockerSnapshotMaxTotalSize, "1024")
 
 	viper.SetDefault(CDockerContainerName, "")
 	viper.SetDefault(CDockerComposeWorkdir, ".")
@@ -58,18 +133,84 @@ func GetInt64(key string) int64 {
 	return viper.GetInt64(key)
 }
 
+func GetFloat64(key string) float64 {
+	return viper.GetFloat64(key)
+}
+
+func GetBool(key string) bool {
+	return viper.GetBool(key)
+}
+
+func GetStringSlice(key string) []string {
+	return viper.GetStringSlice(key)
+}
+
+func GetStringMapString(key string) map[string]string {
+	return viper.GetStringMapString(key)
+}
+
+// UnmarshalKey decodes a structured value of the config like a list of objects
+func UnmarshalKey(key string, out interface{}) error {
+	return viper.UnmarshalKey(key, out)
+}
+
 func GetAddr(hostname string, portname string) string {
 	return fmt.Sprintf("%s:%d", GetString(hostname), GetInt(portname))
 }
 
 func validate() {
 	//Check if salt and key are set
-	if viper.GetString(CReverseProxySessionSalt) == "" {
-		panic("Error: The session salt is not set. Please set it in the config file")
+	if viper.GetString(CReverseProxySessionSalt) == "" && viper.GetString(CReverseProxySessionSaltFile) == "" {
+		panic("Error: The session salt is not set. Please set it in the config file, a salt file or the CTF_REVERSEPROXY_SESSION_SALT environment variable")
+	}
+
+	if viper.GetString(CReverseProxyAuthType) == "http" && viper.GetString(CReverseProxyAuthHTTPUrl) == "" {
+		panic("Error: The session token check url is not set. Please set it in the config file")
+	}
+
+	if viper.GetString(CMgmtKey) == "" && viper.Get(CMgmtKeys) == nil {
+		panic("Error: No management key is set. Please set mgmt.key or mgmt.keys in the config file")
+	}
+
+	if viper.GetInt(CMgmtAuditMaxBackups) == 0 {
+		panic("Error: The audit max backups cannot be 0, the audit log would be deleted when it is rotated. Use -1 to keep every file")
+	}
+
+	if len(viper.GetStringSlice(CWebhookUrls)) > 0 && viper.GetString(CWebhookSecret) == "" {
+		panic("Error: The webhook secret is not set. Please set it in the config file")
+	}
+
+	if viper.GetString(CReverseProxyCaptureDir) != "" {
+		if viper.GetInt64(CReverseProxyCaptureBodySize) < 0 {
+			panic("Error: The capture body size must be greater or equal to 0")
+		}
+		if viper.GetInt64(CReverseProxyCaptureMaxSize) <= 0 || viper.GetInt(CReverseProxyCaptureMaxFiles) <= 0 {
+			panic("Error: The capture max size and max files must be greater than 0")
+		}
+	}
+
+	if strategy := viper.GetString(CReverseProxySharedStrategy); strategy != "round-robin" && strategy != "least-sessions" {
+		panic("Error: The shared instance strategy must be round-robin or least-sessions")
+	}
+
+	if viper.GetBool(CReverseProxySharedEnabled) && viper.GetInt(CReverseProxySharedSessionsPerInstance) <= 0 {
+		panic("Error: The number of sessions per shared instance must be greater than 0")
+	}
+
+	if mode := viper.GetString(CDockerSuspendMode); mode != "pause" && mode != "stop" {
+		panic("Error: The docker suspend mode must be pause or stop")
+	}
+
+	if mode := viper.GetString(CDockerSnapshotMode); mode != "diff" && mode != "files" && mode != "commit" {
+		panic("Error: The docker snapshot mode must be diff, files or commit")
+	}
+
+	if viper.GetInt64(CDockerSnapshotMaxSize) <= 0 {
+		panic("Error: The docker snapshot max size must be greater than 0")
 	}
 
-	if viper.GetString(CMgmtKey) == "" {
-		panic("Error: The management key is not set. Please set it in the config file")
+	if viper.GetInt64(CDockerSnapshotMaxAge) < 0 || viper.GetInt64(CDockerSnapshotMaxTotalSize) < 0 {
+		panic("Error: The docker snapshot max age and max total size cannot be negative")
 	}
 
 	if viper.GetString(CDockerContainerName) == "" {
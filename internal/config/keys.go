@@ -5,15 +5,108 @@ package config
 const CReverseProxyHost = "reverseproxy.host"
 const CReverseProxyPort = "reverseproxy.port"
 const CReverseProxySessionHeader = "reverseproxy.session.header"
-const CReverseProxySessionSalt = "reverseproxy.session.salt"
-const CReverseProxySessionTimeout = "reverseproxy.session.timeout" //Timeout in seconds
-const CReverseProxyPool = "reverseproxy.pool"                      //Basic number of containers that will be created
+const CReverseProxySessionSalt = "reverseproxy.session.salt"                          //Key of the session hashes. Can be set with the CTF_REVERSEPROXY_SESSION_SALT environment variable
+const CReverseProxySessionSaltFile = "reverseproxy.session.salt-file"                 //File containing the key of the session hashes. Takes precedence over the salt
+const CReverseProxySessionKeyringFile = "reverseproxy.session.keyring-file"           //File where the rotated keys are saved. Takes precedence over the salt once written. Empty to keep them in memory
+const CReverseProxySessionTimeout = "reverseproxy.session.timeout"                    //Timeout in seconds
+const CReverseProxySessionMaxLifetime = "reverseproxy.session.max-lifetime"           //Hard limit in seconds from the start of the session. 0 to disable
+const CReverseProxySessionWarning = "reverseproxy.session.warning"                    //Seconds before the hard limit when the player is warned
+const CReverseProxySessionBanner = "reverseproxy.session.banner"                      //Inject a banner in the html pages when the player is warned
+const CReverseProxySessionTeamsFile = "reverseproxy.session.teams-file"               //Json file mapping the teams to the session ids of their members
+const CReverseProxySessionSuspend = "reverseproxy.session.suspend"                    //Idle seconds before the containers of a session are suspended. 0 to disable
+const CReverseProxySessionReservationsFile = "reverseproxy.session.reservations-file" //Json file where the reservations are saved to survive restarts. Empty to disable
+const CReverseProxyQueueAging = "reverseproxy.queue.aging"                            //Seconds waited for a queued request to gain one priority level. 0 for strict priorities
+const CReverseProxyPool = "reverseproxy.pool"                                         //Basic number of containers that will be created
+
+// Shared instances. Many sessions are mapped onto the same instance for stateless challenges
+const CReverseProxySharedEnabled = "reverseproxy.shared.enabled"
+const CReverseProxySharedStrategy = "reverseproxy.shared.strategy"                         //round-robin or least-sessions
+const CReverseProxySharedSessionsPerInstance = "reverseproxy.shared.sessions-per-instance" //Sessions assigned to an instance before another one is used
+const CReverseProxySharedMaxInstances = "reverseproxy.shared.max-instances"                //Instances created when the load grows. The pool size is the minimum. 0 to disable autoscaling
+
+// Header policy applied to the requests and responses going through the reverse proxy
+const CReverseProxyHeadersForwarded = "reverseproxy.headers.forwarded"              //Inject the X-Forwarded-* and Forwarded headers
+const CReverseProxyHeadersInstance = "reverseproxy.headers.instance"                //Name of the header containing the instance identifier. Empty to disable
+const CReverseProxyHeadersRequestAdd = "reverseproxy.headers.request.add"           //Headers added to the request sent to the challenge
+const CReverseProxyHeadersRequestRemove = "reverseproxy.headers.request.remove"     //Headers removed from the request sent to the challenge
+const CReverseProxyHeadersResponseAdd = "reverseproxy.headers.response.add"         //Headers added to the response sent to the player
+const CReverseProxyHeadersResponseRemove = "reverseproxy.headers.response.remove"   //Headers removed from the response sent to the player
+const CReverseProxyHeadersResponseRewrite = "reverseproxy.headers.response.rewrite" //Rewrite the internal container hostname in the response headers
+
+// Structured access log of the proxied requests (JSON lines)
+const CReverseProxyAccessLogFile = "reverseproxy.accesslog.file"              //Path of the access log. Empty to log to the standard output
+const CReverseProxyAccessLogMaxSize = "reverseproxy.accesslog.max-size"       //Size in MB before the file is rotated
+const CReverseProxyAccessLogMaxBackups = "reverseproxy.accesslog.max-backups" //Number of rotated files kept
+
+// Capture of the proxied requests and responses in HAR files per session
+const CReverseProxyCaptureDir = "reverseproxy.capture.dir"            //Directory where the HAR files are saved per session hash. Empty to disable
+const CReverseProxyCaptureBodySize = "reverseproxy.capture.body-size" //Bytes of the request and response bodies kept. 0 to only record the headers
+const CReverseProxyCaptureRedact = "reverseproxy.capture.redact"      //Headers replaced by [redacted]. The session header and the management key are always redacted
+const CReverseProxyCaptureMaxSize = "reverseproxy.capture.max-size"   //Size in MB of a HAR file before a new one is started
+const CReverseProxyCaptureMaxFiles = "reverseproxy.capture.max-files" //HAR files kept per session. The oldest are removed
+
+// Error handling when the container of a session is unreachable
+const CReverseProxyErrorPage = "reverseproxy.error.page"           //Path of the html template rendered. Empty to use the default page
+const CReverseProxyErrorThreshold = "reverseproxy.error.threshold" //Consecutive failures before the container is recycled. 0 to disable
+
+// Limits applied to the players. A value of 0 disables the limit
+const CReverseProxyRateLimitIPRate = "reverseproxy.ratelimit.ip.rate"             //Requests per second per client IP
+const CReverseProxyRateLimitIPBurst = "reverseproxy.ratelimit.ip.burst"           //Requests allowed at once per client IP
+const CReverseProxyRateLimitSessionRate = "reverseproxy.ratelimit.session.rate"   //Requests per second per session
+const CReverseProxyRateLimitSessionBurst = "reverseproxy.ratelimit.session.burst" //Requests allowed at once per session
+const CReverseProxyRateLimitBody = "reverseproxy.ratelimit.body"                  //Max size in bytes of a request body
+const CReverseProxyRateLimitBandwidth = "reverseproxy.ratelimit.bandwidth"        //Download bytes per second per session
+
+const CReverseProxyPowDifficulty = "reverseproxy.pow.difficulty" //Leading zero bits of the proof of work required for new sessions. 0 to disable
+
+// Validation of the session token by the CTF platform
+const CReverseProxyAuthType = "reverseproxy.auth.type"                             //"jwt", "http" or empty to disable
+const CReverseProxyAuthJWTAlgorithm = "reverseproxy.auth.jwt.algorithm"            //HS256 or RS256
+const CReverseProxyAuthJWTKey = "reverseproxy.auth.jwt.key"                        //HS256 secret or RS256 PEM public key
+const CReverseProxyAuthJWTKeyFile = "reverseproxy.auth.jwt.key-file"               //File containing the key. Takes precedence over the key
+const CReverseProxyAuthJWTClaim = "reverseproxy.auth.jwt.claim"                    //Claim used as the session identity
+const CReverseProxyAuthJWTTeamClaim = "reverseproxy.auth.jwt.team-claim"           //Claim containing the team of the player. Empty to disable
+const CReverseProxyAuthJWTIssuer = "reverseproxy.auth.jwt.issuer"                  //Expected issuer. Empty to skip the check
+const CReverseProxyAuthJWTPriorityClaim = "reverseproxy.auth.jwt.priority-claim"   //Claim containing the queue priority of the player. Empty to disable
+const CReverseProxyAuthHTTPUrl = "reverseproxy.auth.http.url"                      //CTFd compatible endpoint returning the user of the token
+const CReverseProxyAuthHTTPHeader = "reverseproxy.auth.http.header"                //Header used to send the token
+const CReverseProxyAuthHTTPScheme = "reverseproxy.auth.http.scheme"                //Prefix of the token in the header
+const CReverseProxyAuthHTTPField = "reverseproxy.auth.http.field"                  //Field of the response data used as the session identity
+const CReverseProxyAuthHTTPPriorityField = "reverseproxy.auth.http.priority-field" //Field of the response data containing the queue priority. Empty to disable
+const CReverseProxyAuthHTTPCache = "reverseproxy.auth.http.cache"                  //Seconds a validated token is cached
+const CReverseProxyAuthHTTPTimeout = "reverseproxy.auth.http.timeout"              //Timeout in seconds of the token check
 
 const CMgmtHost = "mgmt.host"
 const CMgmtPort = "mgmt.port"
-const CMgmtKey = "mgmt.key" //Key used to authenticate to the management interface
+const CMgmtKey = "mgmt.key"                           //Key used to authenticate to the management interface with the full scope
+const CMgmtKeys = "mgmt.keys"                         //Named keys with their scopes. The keys are stored as their sha256 hex digest
+const CMgmtAuthFailures = "mgmt.auth.failures"        //Failed attempts of a client or a presented key before it is locked out. 0 to disable
+const CMgmtAuthLockout = "mgmt.auth.lockout"          //Seconds a client is locked out after too many failed attempts
+const CMgmtAuditFile = "mgmt.audit.file"              //Path of the audit log of the management actions. Empty to log to the standard output
+const CMgmtAuditMaxSize = "mgmt.audit.max-size"       //Size in MB before the file is rotated. 0 to never rotate
+const CMgmtAuditMaxBackups = "mgmt.audit.max-backups" //Number of rotated files kept. -1 keeps every file
+
+// Webhooks receiving the session lifecycle events
+const CWebhookUrls = "webhook.urls"            //Urls receiving the events
+const CWebhookSecret = "webhook.secret"        //Key used to sign the payloads with HMAC-SHA256
+const CWebhookEvents = "webhook.events"        //Events sent to the urls. Empty to send all the events
+const CWebhookSessionId = "webhook.session-id" //Include the session id in the payloads
+const CWebhookRetries = "webhook.retries"      //Attempts after a failed delivery
+const CWebhookQueue = "webhook.queue"          //Payloads waiting per url before new ones are dropped
+const CWebhookTimeout = "webhook.timeout"      //Timeout in seconds of a delivery
 
 const CDockerHost = "docker.host"
+const CDockerSuspendMode = "docker.suspend-mode"          //pause or stop the containers of idle sessions
+const CDockerLogsArchive = "docker.logs.archive"          //Directory where the logs of the containers are saved when an instance is removed. Empty to disable
+const CDockerLogsArchiveTail = "docker.logs.archive-tail" //Last lines saved per container. all to save everything
+
+// Snapshot of the changes made by the players when an instance is removed
+const CDockerSnapshotDir = "docker.snapshot.dir"                     //Directory where the snapshots are saved per session hash. Empty to disable
+const CDockerSnapshotMode = "docker.snapshot.mode"                   //diff, files to also export the changed files or commit to also commit the containers to images
+const CDockerSnapshotMaxSize = "docker.snapshot.max-size"            //Size in MB of the changed files exported per container
+const CDockerSnapshotImage = "docker.snapshot.image"                 //Repository of the committed images
+const CDockerSnapshotMaxAge = "docker.snapshot.max-age"              //Hours a snapshot is kept. 0 keeps them until the total size is reached
+const CDockerSnapshotMaxTotalSize = "docker.snapshot.max-total-size" //Size in MB of the snapshot directory. The oldest snapshots are removed first. 0 for no limit
 
 // Network used by the reverse proxy. This network will be injected into the main container
 const CDockerContainerName = "docker.container-name" //Name of the container that will be created
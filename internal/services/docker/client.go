@@ -6,6 +6,7 @@ import (
 	"log"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/docker/docker/api/types"
@@ -16,6 +17,7 @@ import (
 	"github.com/docker/docker/api/types/strslice"
 	"github.com/docker/go-connections/nat"
 	"github.com/mart123p/ctf-reverseproxy/internal/config"
+	"github.com/mart123p/ctf-reverseproxy/internal/services/sessionmanager"
 )
 
 const ctfReverseProxyLabel = "ctf-reverseproxy.resource"
@@ -71,6 +73,43 @@ func (d *DockerService) upDocker() {
 	log.Printf("[Docker] -> Docker CTF requirements created")
 }
 
+// saveResources archives the logs and saves the snapshots of the resources used by sessions before the shutdown removes them
+func (d *DockerService) saveResources() {
+	containers, err := d.dockerClient.ContainerList(context.Background(), types.ContainerListOptions{All: true})
+	if err != nil {
+		log.Printf("Warning: [Docker] -> Could not list the resources to save, %s", err)
+		return
+	}
+
+	resources := make(map[int][]types.Container)
+	for _, container := range containers {
+		if !isCtfResource(container.Labels) {
+			continue
+		}
+		ctfId, err := strconv.Atoi(container.Labels[ctfReverseProxyIdLabel])
+		if err != nil {
+			continue
+		}
+		resources[ctfId] = append(resources[ctfId], container)
+	}
+
+	var wg sync.WaitGroup
+	for ctfId, containers := range resources {
+		sessions := sessionmanager.TakeInstanceSessions(d.getAddr(ctfId))
+		if len(sessions) == 0 {
+			continue
+		}
+
+		wg.Add(1)
+		go func(ctfId int, containers []types.Container) {
+			defer wg.Done()
+			d.archiveLogs(ctfId, containers)
+			d.snapshot(ctfId, containers, sessions)
+		}(ctfId, containers)
+	}
+	wg.Wait()
+}
+
 // downDocker destroy all containers and networks
 func (d *DockerService) downDocker() {
 	log.Printf("[Docker] -> Starting removing CTF docker resources")
@@ -108,26 +147,61 @@ func (d *DockerService) downDocker() {
 	log.Printf("[Docker] -> CTF docker resources removed")
 }
 
+// stopResource removes the resources of the ctf id outside of the run loop. The logs and the snapshot are saved before the containers are removed
 func (d *DockerService) stopResource(ctfId int) {
+	if d.removing[ctfId] {
+		return
+	}
 	log.Printf("[Docker] -> Stopping resources %d", ctfId)
 
-	//Stop the containers
-	containers, err := d.dockerClient.ContainerList(context.Background(), types.ContainerListOptions{})
+	//Stop the containers. Suspended containers are paused or stopped
+	containers, err := d.dockerClient.ContainerList(context.Background(), types.ContainerListOptions{All: true})
 	if err != nil {
 		panic(err)
 	}
+	delete(d.suspended, ctfId)
 
 	ctfIdStr := fmt.Sprintf("%d", ctfId)
+
+	resources := make([]types.Container, 0)
 	for _, container := range containers {
 		if isCtfResource(container.Labels) && isCtfId(container.Labels, ctfIdStr) {
-			d.dockerClient.ContainerRemove(context.Background(), container.ID, types.ContainerRemoveOptions{
-				Force: true,
-			})
-			log.Printf("[Docker] -> Removed container \"%v\" id: %s", container.Names, container.ID)
+			resources = append(resources, container)
 		}
 	}
+	sessions := sessionmanager.TakeInstanceSessions(d.getAddr(ctfId))
+
+	//The state check ignores the resource until it is removed
+	d.removing[ctfId] = true
+	d.removals.Add(1)
+	go func() {
+		defer d.removals.Done()
+		d.removeResource(ctfId, resources, sessions)
+
+		select {
+		case d.removed <- ctfId:
+		case <-d.shutdown:
+		}
+	}()
+}
+
+// removeResource archives the logs, saves the snapshot and removes the containers and the networks of the ctf id
+func (d *DockerService) removeResource(ctfId int, resources []types.Container, sessions []string) {
+	d.archiveLogs(ctfId, resources)
+	d.snapshot(ctfId, resources, sessions)
+
+	for _, container := range resources {
+		if container.State == "paused" {
+			d.dockerClient.ContainerUnpause(context.Background(), container.ID)
+		}
+		d.dockerClient.ContainerRemove(context.Background(), container.ID, types.ContainerRemoveOptions{
+			Force: true,
+		})
+		log.Printf("[Docker] -> Removed container \"%v\" id: %s", container.Names, container.ID)
+	}
 
 	//Remove the networks
+	ctfIdStr := fmt.Sprintf("%d", ctfId)
 	networks, err := d.dockerClient.NetworkList(context.Background(), types.NetworkListOptions{})
 	if err != nil {
 		panic(err)
@@ -396,6 +470,7 @@ func (d *DockerService) startResource(ctfId int) string {
 
 func (d *DockerService) checkState() ([]string, []string) {
 	containersCount := make(map[int]int)
+	current := make(map[int]*Instance)
 
 	//Get the current container
 	ctfProxyContainer, err := d.dockerClient.ContainerInspect(context.Background(), d.containerId)
@@ -403,12 +478,13 @@ func (d *DockerService) checkState() ([]string, []string) {
 		panic(err)
 	}
 
-	containers, err := d.dockerClient.ContainerList(context.Background(), types.ContainerListOptions{})
+	containers, err := d.dockerClient.ContainerList(context.Background(), types.ContainerListOptions{All: true})
 	if err != nil {
 		panic(err)
 	}
 
 	ctfId_max := 0
+	now := time.Now()
 
 	for _, container := range containers {
 		if isCtfResource(container.Labels) {
@@ -426,12 +502,21 @@ func (d *DockerService) checkState() ([]string, []string) {
 					ctfId_max = ctfId
 				}
 
+				//Being removed outside of the run loop
+				if d.removing[ctfId] {
+					continue
+				}
+
 				if _, ok := containersCount[ctfId]; !ok {
 					containersCount[ctfId] = 0
+					current[ctfId] = &Instance{CtfId: ctfId, Addr: d.getAddr(ctfId), Containers: make([]Container, 0)}
 				}
+				current[ctfId].Containers = append(current[ctfId].Containers, d.describeContainer(container, now))
 
-				//Check if the container is running
-				if container.State != "running" && container.State != "created" {
+				//Check if the container is running. The containers of a suspended session are paused or stopped
+				if d.suspended[ctfId] && (container.State == "paused" || container.State == "exited") {
+					containersCount[ctfId]++
+				} else if container.State != "running" && container.State != "created" {
 					log.Printf("Warning: [Docker] -> Container %s is not running", container.ID)
 				} else {
 					containersCount[ctfId]++
@@ -471,10 +556,15 @@ func (d *DockerService) checkState() ([]string, []string) {
 			log.Printf("[Docker] -> Container count mismatch. Required: %d, Found: %d. Removing resource: %d", requiredContainerCount, countainerCount, ctfId)
 			d.stopResource(ctfId)
 			dirty = append(dirty, addr)
+			delete(current, ctfId)
 		} else {
 			state = append(state, addr)
 		}
 	}
 
+	//The management api reads the instances from the snapshot
+	instances.set(current)
+	d.pruneDetails(containers)
+
 	return dirty, state
 }
@@ -4,6 +4,7 @@ import (
 	"log"
 	"regexp"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/docker/docker/client"
@@ -18,11 +19,22 @@ type DockerService struct {
 
 	dockerRequest cbroadcast.Channel
 	dockerStop    cbroadcast.Channel
+	dockerSuspend cbroadcast.Channel
+	dockerResume  cbroadcast.Channel
 
 	containerId string //Id of the current container
 
 	currentId int //Id used to increment everytime a new container is deployed
 
+	suspended map[int]bool //Ctf ids of the resources that are paused or stopped
+
+	removing map[int]bool   //Ctf ids of the resources being removed outside of the run loop
+	removed  chan int       //Ctf ids of the resources removed
+	removals sync.WaitGroup //Removals waited for before the shutdown
+
+	details map[string]containerDetails //Inspected containers by id. Only used by the run loop
+	digests map[string]string           //Repository digests by image id. Only used by the run loop
+
 	compose      composeFile
 	dockerClient *client.Client
 
@@ -33,6 +45,11 @@ func (d *DockerService) Init() {
 	d.shutdown = make(chan bool)
 	d.currentId = 1
 	d.containerId = ""
+	d.suspended = make(map[int]bool)
+	d.removing = make(map[int]bool)
+	d.removed = make(chan int)
+	d.details = make(map[string]containerDetails)
+	d.digests = make(map[string]string)
 
 	d.compose = composeFile{}
 
@@ -54,6 +71,7 @@ func (d *DockerService) Start() {
 
 	d.validation()
 
+	singleton = d
 	go d.run()
 }
 
@@ -78,6 +96,8 @@ func (d *DockerService) run() {
 	for {
 		select {
 		case <-d.shutdown:
+			d.removals.Wait()
+			d.saveResources()
 			d.downDocker()
 			log.Printf("[Docker] -> Docker service closed")
 			return
@@ -96,12 +116,7 @@ func (d *DockerService) run() {
 			}
 
 			addr := containerAddr.(string)
-			matches := d.reAddrCtfId.FindStringSubmatch(addr)
-			ctfId := -1
-			if len(matches) >= 2 {
-				ctfId, _ = strconv.Atoi(matches[1])
-			}
-
+			ctfId := d.getCtfId(addr)
 			if ctfId == -1 {
 				log.Fatalf("[Docker] -> Docker stop received invalid address %s", addr)
 			}
@@ -110,6 +125,39 @@ func (d *DockerService) run() {
 
 			cbroadcast.Broadcast(BDockerStop, addr)
 
+		case containerAddr := <-d.dockerSuspend:
+			addr := containerAddr.(string)
+			log.Printf("[Docker] -> Docker suspend received %s", addr)
+
+			ctfId := d.getCtfId(addr)
+			if ctfId == -1 {
+				log.Printf("Warning: [Docker] -> Docker suspend received invalid address %s", addr)
+				continue
+			}
+
+			d.suspendResource(ctfId)
+
+		case containerAddr := <-d.dockerResume:
+			addr := containerAddr.(string)
+			log.Printf("[Docker] -> Docker resume received %s", addr)
+
+			ctfId := d.getCtfId(addr)
+			if ctfId == -1 {
+				log.Printf("Warning: [Docker] -> Docker resume received invalid address %s", addr)
+				continue
+			}
+
+			if d.resumeResource(ctfId) {
+				cbroadcast.Broadcast(BDockerResumed, addr)
+			} else {
+				//The session manager gives a new container to the session
+				d.stopResource(ctfId)
+				cbroadcast.Broadcast(BDockerStop, addr)
+			}
+
+		case ctfId := <-d.removed:
+			delete(d.removing, ctfId)
+
 		case <-ticker.C:
 			dirty, state := d.checkState()
 			for _, addr := range dirty {
@@ -126,4 +174,16 @@ func (d *DockerService) run() {
 func (d *DockerService) subscribe() {
 	d.dockerRequest, _ = cbroadcast.Subscribe(sessionmanager.BSessionRequest)
 	d.dockerStop, _ = cbroadcast.Subscribe(sessionmanager.BSessionStop)
+	d.dockerSuspend, _ = cbroadcast.Subscribe(sessionmanager.BSessionSuspend)
+	d.dockerResume, _ = cbroadcast.Subscribe(sessionmanager.BSessionResume)
+}
+
+// getCtfId returns the ctf id of the container address. Returns -1 if the address is invalid
+func (d *DockerService) getCtfId(addr string) int {
+	matches := d.reAddrCtfId.FindStringSubmatch(addr)
+	ctfId := -1
+	if len(matches) >= 2 {
+		ctfId, _ = strconv.Atoi(matches[1])
+	}
+	return ctfId
 }
@@ -0,0 +1,102 @@
+package docker
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+
+	"github.com/docker/docker/api/types"
+)
+
+var ErrContainerNotFound = errors.New("container not found")
+
+// ExecSession is an interactive tty opened in a container
+type ExecSession struct {
+	d         *DockerService
+	id        string
+	Container string //Name of the container
+
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// Exec opens a tty running cmd in the container of the instance at addr. The container is the name of a compose service or of a container.
+// The container of the main service is used when it is empty
+func Exec(ctx context.Context, addr string, container string, cmd []string) (*ExecSession, error) {
+	d, containers, err := instanceContainers(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	ctfId := d.getCtfId(addr)
+
+	if container == "" {
+		container = d.compose.project.Services[d.compose.mainService].Name
+	}
+
+	//The service name is matched against the name given to its container
+	target := ""
+	for _, c := range containers {
+		name := containerName(c)
+		if name == container || name == getName(container, ctfId) {
+			target = name
+			break
+		}
+	}
+	if target == "" {
+		return nil, ErrContainerNotFound
+	}
+
+	exec, err := d.dockerClient.ContainerExecCreate(ctx, target, types.ExecConfig{
+		Tty:          true,
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+		Cmd:          cmd,
+		Env:          []string{"TERM=xterm-256color"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	attach, err := d.dockerClient.ContainerExecAttach(ctx, exec.ID, types.ExecStartCheck{Tty: true})
+	if err != nil {
+		return nil, err
+	}
+
+	return &ExecSession{
+		d:         d,
+		id:        exec.ID,
+		Container: target,
+		conn:      attach.Conn,
+		reader:    attach.Reader,
+	}, nil
+}
+
+// Read the output of the tty
+func (e *ExecSession) Read(p []byte) (int, error) {
+	return e.reader.Read(p)
+}
+
+// Write to the input of the tty
+func (e *ExecSession) Write(p []byte) (int, error) {
+	return e.conn.Write(p)
+}
+
+// Resize the tty
+func (e *ExecSession) Resize(ctx context.Context, rows uint, cols uint) error {
+	return e.d.dockerClient.ContainerExecResize(ctx, e.id, types.ResizeOptions{Height: rows, Width: cols})
+}
+
+// ExitCode returns the exit code of the command. Returns -1 while it is running
+func (e *ExecSession) ExitCode(ctx context.Context) int {
+	inspect, err := e.d.dockerClient.ContainerExecInspect(ctx, e.id)
+	if err != nil || inspect.Running {
+		return -1
+	}
+	return inspect.ExitCode
+}
+
+func (e *ExecSession) Close() error {
+	return e.conn.Close()
+}
@@ -0,0 +1,217 @@
+package docker
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+// Container describes a container of an instance
+type Container struct {
+	Name         string
+	Image        string
+	ImageDigest  string //Digest of the image in its repository. The id of the image when it was built locally
+	State        string //running, paused, exited...
+	Health       string `json:",omitempty"` //Empty when the container has no health check
+	RestartCount int
+	StartedOn    int64 `json:",omitempty"`
+	Uptime       int64 //Seconds since the container started. 0 when it is not running
+	Networks     []string
+}
+
+// Instance is the set of containers created for a ctf id
+type Instance struct {
+	CtfId      int
+	Addr       string
+	Containers []Container
+}
+
+// snapshot keeps the instances found by the last state check for the management api
+type snapshot struct {
+	mu        sync.RWMutex
+	instances map[int]*Instance
+	updatedOn time.Time
+}
+
+var instances = snapshot{
+	instances: make(map[int]*Instance),
+}
+
+func (s *snapshot) set(current map[int]*Instance) {
+	for _, instance := range current {
+		containers := instance.Containers
+		sort.Slice(containers, func(i, j int) bool {
+			return containers[i].Name < containers[j].Name
+		})
+	}
+
+	s.mu.Lock()
+	s.instances = current
+	s.updatedOn = time.Now()
+	s.mu.Unlock()
+}
+
+// SetInstances replaces the instances read by the management api. The tests of the api use it in place of a docker daemon
+func SetInstances(current []Instance) {
+	found := make(map[int]*Instance, len(current))
+	for i := range current {
+		found[current[i].CtfId] = &current[i]
+	}
+	instances.set(found)
+}
+
+// copyInstance returns a copy of the instance with the uptime of its containers
+func copyInstance(instance *Instance, now time.Time) Instance {
+	result := *instance
+	result.Containers = make([]Container, len(instance.Containers))
+	copy(result.Containers, instance.Containers)
+	for i := range result.Containers {
+		container := &result.Containers[i]
+		if container.State == "running" && container.StartedOn > 0 {
+			container.Uptime = now.Unix() - container.StartedOn
+		}
+	}
+	return result
+}
+
+// GetInstances returns the instances found by the last state check and the time of the check
+func GetInstances() ([]Instance, time.Time) {
+	now := time.Now()
+	instances.mu.RLock()
+	result := make([]Instance, 0, len(instances.instances))
+	for _, instance := range instances.instances {
+		result = append(result, copyInstance(instance, now))
+	}
+	updatedOn := instances.updatedOn
+	instances.mu.RUnlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CtfId < result[j].CtfId
+	})
+	return result, updatedOn
+}
+
+// GetInstance returns the instance of the ctf id found by the last state check
+func GetInstance(ctfId int) (Instance, bool) {
+	instances.mu.RLock()
+	defer instances.mu.RUnlock()
+
+	instance, ok := instances.instances[ctfId]
+	if !ok {
+		return Instance{}, false
+	}
+	return copyInstance(instance, time.Now()), true
+}
+
+// Time during which the inspected details of a container are reused when its state did not change
+const detailsTTL = 30 * time.Second
+
+// containerDetails are the details that are only given by an inspect
+type containerDetails struct {
+	state        string //State of the container when it was inspected
+	inspectedOn  time.Time
+	restartCount int
+	health       string
+	startedOn    int64
+}
+
+// describeContainer is called by the state check. The containers are inspected again when their state changes or
+// when the details are stale so the management api only reads the snapshot
+func (d *DockerService) describeContainer(container types.Container, now time.Time) Container {
+	result := Container{
+		Image:       container.Image,
+		ImageDigest: d.imageDigest(container.ImageID),
+		State:       container.State,
+		Networks:    make([]string, 0),
+	}
+	if len(container.Names) > 0 {
+		result.Name = strings.TrimPrefix(container.Names[0], "/")
+	}
+	if container.NetworkSettings != nil {
+		for name := range container.NetworkSettings.Networks {
+			result.Networks = append(result.Networks, name)
+		}
+		sort.Strings(result.Networks)
+	}
+
+	cached, ok := d.details[container.ID]
+	if !ok || cached.state != container.State || now.Sub(cached.inspectedOn) > detailsTTL {
+		inspected, err := d.inspectContainer(container.ID)
+		if err == nil {
+			inspected.state = container.State
+			inspected.inspectedOn = now
+			cached = inspected
+			d.details[container.ID] = cached
+		}
+	}
+	result.RestartCount = cached.restartCount
+	result.Health = cached.health
+	result.StartedOn = cached.startedOn
+	return result
+}
+
+// inspectContainer returns the details of the container
+func (d *DockerService) inspectContainer(id string) (containerDetails, error) {
+	var result containerDetails
+
+	inspected, err := d.dockerClient.ContainerInspect(context.Background(), id)
+	if err != nil {
+		return result, err
+	}
+
+	result.restartCount = inspected.RestartCount
+	if inspected.State != nil {
+		if inspected.State.Health != nil {
+			result.health = inspected.State.Health.Status
+		}
+		if startedAt, err := time.Parse(time.RFC3339Nano, inspected.State.StartedAt); err == nil && !startedAt.IsZero() {
+			result.startedOn = startedAt.Unix()
+		}
+	}
+	return result, nil
+}
+
+// imageDigest returns the repository digest of the image. The images never change so the digest is kept
+func (d *DockerService) imageDigest(imageId string) string {
+	if digest, ok := d.digests[imageId]; ok {
+		return digest
+	}
+
+	image, _, err := d.dockerClient.ImageInspectWithRaw(context.Background(), imageId)
+	if err != nil {
+		return imageId
+	}
+
+	//Built locally when the image was never pushed or pulled
+	digest := imageId
+	if len(image.RepoDigests) > 0 {
+		repoDigest := image.RepoDigests[0]
+		digest = repoDigest[strings.LastIndex(repoDigest, "@")+1:]
+	}
+	d.digests[imageId] = digest
+	return digest
+}
+
+// pruneDetails forgets the containers and the images that are not part of the instances anymore
+func (d *DockerService) pruneDetails(containers []types.Container) {
+	ids := make(map[string]bool)
+	for _, container := range containers {
+		ids[container.ID] = true
+		ids[container.ImageID] = true
+	}
+
+	for id := range d.details {
+		if !ids[id] {
+			delete(d.details, id)
+		}
+	}
+	for id := range d.digests {
+		if !ids[id] {
+			delete(d.digests, id)
+		}
+	}
+}
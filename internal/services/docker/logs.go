@@ -0,0 +1,243 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/mart123p/ctf-reverseproxy/internal/config"
+)
+
+var ErrInstanceNotFound = errors.New("instance not found")
+
+var errDockerUnavailable = errors.New("the docker service is not started")
+
+// LogOptions selects the logs returned by StreamLogs
+type LogOptions struct {
+	Tail       string //Last lines of each container. Empty or all for everything
+	Since      string //RFC3339 time, unix timestamp or duration like 10m
+	Follow     bool
+	Timestamps bool
+}
+
+var singleton *DockerService
+
+// prefixWriter writes the complete lines of a container with its name. The lines of the containers are not mixed
+type prefixWriter struct {
+	mu     *sync.Mutex
+	out    io.Writer
+	flush  func()
+	prefix []byte
+	line   []byte
+}
+
+func (p *prefixWriter) Write(data []byte) (int, error) {
+	p.line = append(p.line, data...)
+
+	index := bytes.LastIndexByte(p.line, '\n')
+	if index == -1 {
+		return len(data), nil
+	}
+
+	var buffer bytes.Buffer
+	for _, line := range bytes.SplitAfter(p.line[:index+1], []byte{'\n'}) {
+		if len(line) > 0 {
+			buffer.Write(p.prefix)
+			buffer.Write(line)
+		}
+	}
+	p.line = append(p.line[:0], p.line[index+1:]...)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, err := p.out.Write(buffer.Bytes()); err != nil {
+		return 0, err
+	}
+	p.flush()
+	return len(data), nil
+}
+
+// close writes the last line when it does not end with a new line
+func (p *prefixWriter) close() {
+	if len(p.line) > 0 {
+		p.Write([]byte{'\n'})
+	}
+}
+
+// listContainers returns the containers of the ctf id sorted by name
+func (d *DockerService) listContainers(ctx context.Context, ctfId int) ([]types.Container, error) {
+	containers, err := d.dockerClient.ContainerList(ctx, types.ContainerListOptions{
+		All: true,
+		Filters: filters.NewArgs(
+			filters.Arg("label", fmt.Sprintf("%s=true", ctfReverseProxyLabel)),
+			filters.Arg("label", fmt.Sprintf("%s=%d", ctfReverseProxyIdLabel, ctfId)),
+		),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(containers, func(i, j int) bool {
+		return containerName(containers[i]) < containerName(containers[j])
+	})
+	return containers, nil
+}
+
+func containerName(container types.Container) string {
+	if len(container.Names) > 0 {
+		return strings.TrimPrefix(container.Names[0], "/")
+	}
+	return container.ID
+}
+
+// copyLogs writes the logs of the container to stdout and stderr
+func (d *DockerService) copyLogs(ctx context.Context, container types.Container, options types.ContainerLogsOptions, stdout io.Writer, stderr io.Writer) error {
+	details, err := d.dockerClient.ContainerInspect(ctx, container.ID)
+	if err != nil {
+		return err
+	}
+
+	reader, err := d.dockerClient.ContainerLogs(ctx, container.ID, options)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	//The output of a tty is not multiplexed
+	if details.Config != nil && details.Config.Tty {
+		_, err = io.Copy(stdout, reader)
+	} else {
+		_, err = stdcopy.StdCopy(stdout, stderr, reader)
+	}
+	return err
+}
+
+// InstanceLogs gives access to the logs of the containers of an instance
+type InstanceLogs struct {
+	d          *DockerService
+	containers []types.Container
+}
+
+// instanceContainers returns the containers of the instance at addr
+func instanceContainers(ctx context.Context, addr string) (*DockerService, []types.Container, error) {
+	if singleton == nil || singleton.dockerClient == nil {
+		return nil, nil, errDockerUnavailable
+	}
+	d := singleton
+
+	ctfId := d.getCtfId(addr)
+	if ctfId == -1 {
+		return nil, nil, ErrInstanceNotFound
+	}
+
+	containers, err := d.listContainers(ctx, ctfId)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(containers) == 0 {
+		return nil, nil, ErrInstanceNotFound
+	}
+	return d, containers, nil
+}
+
+// GetInstanceLogs returns the logs of the instance at addr
+func GetInstanceLogs(ctx context.Context, addr string) (*InstanceLogs, error) {
+	d, containers, err := instanceContainers(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	return &InstanceLogs{d: d, containers: containers}, nil
+}
+
+// Stream writes the logs of every container. Each line starts with the name of its container.
+// With follow, the containers are streamed at the same time until the context is done
+func (l *InstanceLogs) Stream(ctx context.Context, options LogOptions, w io.Writer, flush func()) {
+	logOptions := types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Tail:       options.Tail,
+		Since:      options.Since,
+		Follow:     options.Follow,
+		Timestamps: options.Timestamps,
+	}
+
+	mu := &sync.Mutex{}
+	stream := func(container types.Container) {
+		prefix := []byte(containerName(container) + " | ")
+		stdout := &prefixWriter{mu: mu, out: w, flush: flush, prefix: prefix}
+		stderr := &prefixWriter{mu: mu, out: w, flush: flush, prefix: prefix}
+		if err := l.d.copyLogs(ctx, container, logOptions, stdout, stderr); err != nil && ctx.Err() == nil {
+			log.Printf("Warning: [Docker] -> Could not read the logs of the container \"%s\", %s", containerName(container), err)
+		}
+		stdout.close()
+		stderr.close()
+	}
+
+	if !options.Follow {
+		for _, container := range l.containers {
+			stream(container)
+		}
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, container := range l.containers {
+		wg.Add(1)
+		go func(container types.Container) {
+			defer wg.Done()
+			stream(container)
+		}(container)
+	}
+	wg.Wait()
+}
+
+// archiveLogs saves the logs of the containers of the ctf id before they are removed
+func (d *DockerService) archiveLogs(ctfId int, containers []types.Container) {
+	dir := config.GetString(config.CDockerLogsArchive)
+	if dir == "" {
+		return
+	}
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		log.Printf("Warning: [Docker] -> Could not create the logs archive \"%s\", %s", dir, err)
+		return
+	}
+
+	options := types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Timestamps: true,
+		Tail:       config.GetString(config.CDockerLogsArchiveTail),
+	}
+
+	//The logs are not worth delaying the removal of the resource for long
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	now := time.Now().Unix()
+	for _, container := range containers {
+		path := filepath.Join(dir, fmt.Sprintf("%d-%s-%d.log", ctfId, containerName(container), now))
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0640)
+		if err != nil {
+			log.Printf("Warning: [Docker] -> Could not create the log archive \"%s\", %s", path, err)
+			continue
+		}
+
+		if err := d.copyLogs(ctx, container, options, file, file); err != nil {
+			log.Printf("Warning: [Docker] -> Could not archive the logs of the container \"%s\", %s", containerName(container), err)
+		}
+		file.Close()
+	}
+	log.Printf("[Docker] -> Logs of resource %d archived in \"%s\"", ctfId, dir)
+}
@@ -0,0 +1,428 @@
+package docker
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/mart123p/ctf-reverseproxy/internal/config"
+	"github.com/mart123p/ctf-reverseproxy/pkg/filename"
+)
+
+var ErrSnapshotsDisabled = errors.New("the snapshot directory is not set")
+var ErrSnapshotNotFound = errors.New("snapshot not found")
+
+// File describing a snapshot in its directory
+const snapshotManifest = "snapshot.json"
+
+// Snapshot describes the changes made in the containers of an instance before it was removed
+type Snapshot struct {
+	Id          string //Ctf id and time of the removal
+	SessionHash string `json:",omitempty"`
+	CtfId       int
+	Time        int64
+	Sessions    []string //Hashes of the sessions that used the instance
+	Containers  []ContainerSnapshot
+	Files       []SnapshotFile `json:",omitempty"` //Files that can be downloaded
+}
+
+type ContainerSnapshot struct {
+	Name      string
+	Changes   int    //Paths added, changed or deleted
+	Exported  int    `json:",omitempty"` //Changed files exported
+	Truncated bool   `json:",omitempty"` //The exported files reached the max size
+	Image     string `json:",omitempty"` //Image the container was committed to
+	Error     string `json:",omitempty"`
+}
+
+type SnapshotFile struct {
+	Name string
+	Size int64
+}
+
+// snapshot saves the changes made in the containers of the ctf id before they are removed. The resources never assigned to a session are skipped
+func (d *DockerService) snapshot(ctfId int, containers []types.Container, sessions []string) {
+	dir := config.GetString(config.CDockerSnapshotDir)
+	if dir == "" || len(sessions) == 0 {
+		return
+	}
+
+	now := time.Now().Unix()
+	snapshot := Snapshot{
+		Id:         fmt.Sprintf("%d-%d", ctfId, now),
+		CtfId:      ctfId,
+		Time:       now,
+		Sessions:   sessions,
+		Containers: make([]ContainerSnapshot, 0, len(containers)),
+	}
+
+	//The snapshot is written once and linked in the directories of the other sessions
+	target := filepath.Join(dir, snapshot.Sessions[0], snapshot.Id)
+	if err := os.MkdirAll(target, 0750); err != nil {
+		log.Printf("Warning: [Docker] -> Could not create the snapshot \"%s\", %s", target, err)
+		return
+	}
+
+	//The removal of the resource waits for the snapshot
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	mode := config.GetString(config.CDockerSnapshotMode)
+	for _, c := range containers {
+		result := d.snapshotContainer(ctx, c, target, mode, snapshot.Id)
+		if result.Error != "" {
+			log.Printf("Warning: [Docker] -> Could not snapshot the container \"%s\", %s", result.Name, result.Error)
+		}
+		snapshot.Containers = append(snapshot.Containers, result)
+	}
+
+	manifest, _ := json.MarshalIndent(snapshot, "", "  ")
+	if err := os.WriteFile(filepath.Join(target, snapshotManifest), manifest, 0640); err != nil {
+		log.Printf("Warning: [Docker] -> Could not write the snapshot \"%s\", %s", target, err)
+		return
+	}
+
+	for _, sessionHash := range snapshot.Sessions[1:] {
+		if err := linkSnapshot(target, filepath.Join(dir, sessionHash, snapshot.Id)); err != nil {
+			log.Printf("Warning: [Docker] -> Could not link the snapshot \"%s\" to the session %s, %s", snapshot.Id, sessionHash, err)
+		}
+	}
+	log.Printf("[Docker] -> Snapshot of resource %d saved in \"%s\" | Sessions: %d", ctfId, target, len(snapshot.Sessions))
+
+	pruneSnapshots(dir)
+}
+
+// Held while the snapshots are pruned. Many resources can be removed at once
+var pruneLock sync.Mutex
+
+// pruneSnapshots removes the snapshots older than the max age, then the oldest ones while the directory is above the max total size
+func pruneSnapshots(dir string) {
+	pruneLock.Lock()
+	defer pruneLock.Unlock()
+
+	maxAge := config.GetInt64(config.CDockerSnapshotMaxAge) * 3600
+	maxTotalSize := config.GetInt64(config.CDockerSnapshotMaxTotalSize) * 1024 * 1024
+	if maxAge == 0 && maxTotalSize == 0 {
+		return
+	}
+
+	//A snapshot linked in the directories of many sessions is stored once
+	type storedSnapshot struct {
+		time  int64
+		size  int64
+		paths []string
+	}
+	stored := make(map[string]*storedSnapshot)
+
+	sessionDirs, err := os.ReadDir(dir)
+	if err != nil {
+		log.Printf("Warning: [Docker] -> Could not read the snapshot directory \"%s\", %s", dir, err)
+		return
+	}
+	for _, sessionDir := range sessionDirs {
+		if !sessionDir.IsDir() {
+			continue
+		}
+		entries, err := os.ReadDir(filepath.Join(dir, sessionDir.Name()))
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			path := filepath.Join(dir, sessionDir.Name(), entry.Name())
+			snapshot, err := readSnapshot(path, sessionDir.Name())
+			if err != nil {
+				//Snapshot being written
+				continue
+			}
+
+			s, ok := stored[snapshot.Id]
+			if !ok {
+				s = &storedSnapshot{time: snapshot.Time}
+				for _, file := range snapshot.Files {
+					s.size += file.Size
+				}
+				stored[snapshot.Id] = s
+			}
+			s.paths = append(s.paths, path)
+		}
+	}
+
+	snapshots := make([]*storedSnapshot, 0, len(stored))
+	totalSize := int64(0)
+	for _, s := range stored {
+		snapshots = append(snapshots, s)
+		totalSize += s.size
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].time < snapshots[j].time
+	})
+
+	now := time.Now().Unix()
+	removed := 0
+	for _, s := range snapshots {
+		expired := maxAge > 0 && now-s.time > maxAge
+		full := maxTotalSize > 0 && totalSize > maxTotalSize
+		if !expired && !full {
+			break
+		}
+
+		for _, path := range s.paths {
+			if err := os.RemoveAll(path); err != nil {
+				log.Printf("Warning: [Docker] -> Could not remove the snapshot \"%s\", %s", path, err)
+			}
+			//Only removed once it is empty
+			os.Remove(filepath.Dir(path))
+		}
+		totalSize -= s.size
+		removed++
+	}
+
+	if removed > 0 {
+		log.Printf("[Docker] -> Old snapshots removed | Snapshots: %d | Size: %d MB", removed, totalSize/1024/1024)
+	}
+}
+
+// snapshotContainer writes the diff of the container and exports or commits its changes depending on the mode
+func (d *DockerService) snapshotContainer(ctx context.Context, c types.Container, dir string, mode string, id string) ContainerSnapshot {
+	result := ContainerSnapshot{Name: containerName(c)}
+
+	changes, err := d.dockerClient.ContainerDiff(ctx, c.ID)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Changes = len(changes)
+
+	//Same format as docker diff
+	var diff strings.Builder
+	for _, change := range changes {
+		fmt.Fprintf(&diff, "%s %s\n", change.Kind, change.Path)
+	}
+	if err := os.WriteFile(filepath.Join(dir, result.Name+".diff"), []byte(diff.String()), 0640); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	switch mode {
+	case "files":
+		file, err := os.OpenFile(filepath.Join(dir, result.Name+".tar.gz"), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0640)
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		maxSize := config.GetInt64(config.CDockerSnapshotMaxSize) * 1024 * 1024
+		result.Exported, result.Truncated, err = d.exportChanges(ctx, c, changes, file, maxSize)
+		file.Close()
+		if err != nil {
+			result.Error = err.Error()
+		}
+
+	case "commit":
+		image := fmt.Sprintf("%s:%s-%s", config.GetString(config.CDockerSnapshotImage), id, result.Name)
+		_, err := d.dockerClient.ContainerCommit(ctx, c.ID, types.ContainerCommitOptions{
+			Reference: image,
+			Comment:   fmt.Sprintf("Snapshot %s of the ctf reverse proxy", id),
+			Pause:     c.State == "running",
+		})
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		result.Image = image
+	}
+	return result
+}
+
+// exportChanges writes the paths added or changed in the container to a gzipped tar. Stops before maxSize bytes of files
+func (d *DockerService) exportChanges(ctx context.Context, c types.Container, changes []container.FilesystemChange, w io.Writer, maxSize int64) (int, bool, error) {
+	//The parents of a change are listed as changed. Only the deepest paths are copied
+	parents := make(map[string]bool)
+	for _, change := range changes {
+		for dir := path.Dir(change.Path); dir != "/" && dir != "."; dir = path.Dir(dir) {
+			parents[dir] = true
+		}
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	exported := 0
+	size := int64(0)
+	truncated := false
+	for _, change := range changes {
+		if change.Kind == container.ChangeDelete || parents[change.Path] {
+			continue
+		}
+
+		reader, _, err := d.dockerClient.CopyFromContainer(ctx, c.ID, change.Path)
+		if err != nil {
+			//Sockets and files removed while copying
+			continue
+		}
+
+		//The names in the archive are relative to the parent of the path
+		tr := tar.NewReader(reader)
+		for {
+			header, err := tr.Next()
+			if err != nil {
+				break
+			}
+			if size+header.Size > maxSize {
+				truncated = true
+				break
+			}
+			header.Name = strings.TrimPrefix(path.Join(path.Dir(change.Path), header.Name), "/")
+			if err := tw.WriteHeader(header); err != nil {
+				reader.Close()
+				return exported, truncated, err
+			}
+			if _, err := io.Copy(tw, tr); err != nil {
+				reader.Close()
+				return exported, truncated, err
+			}
+			size += header.Size
+			if header.Typeflag == tar.TypeReg {
+				exported++
+			}
+		}
+		reader.Close()
+
+		if truncated {
+			break
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return exported, truncated, err
+	}
+	return exported, truncated, gz.Close()
+}
+
+// linkSnapshot hard links the files of a snapshot in another directory
+func linkSnapshot(source string, target string) error {
+	if err := os.MkdirAll(target, 0750); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(source)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := os.Link(filepath.Join(source, entry.Name()), filepath.Join(target, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readSnapshot returns the snapshot saved in dir with its files
+func readSnapshot(dir string, sessionHash string) (Snapshot, error) {
+	var snapshot Snapshot
+
+	manifest, err := os.ReadFile(filepath.Join(dir, snapshotManifest))
+	if err != nil {
+		return snapshot, err
+	}
+	if err := json.Unmarshal(manifest, &snapshot); err != nil {
+		return snapshot, err
+	}
+	snapshot.SessionHash = sessionHash
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return snapshot, err
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || entry.IsDir() || entry.Name() == snapshotManifest {
+			continue
+		}
+		snapshot.Files = append(snapshot.Files, SnapshotFile{Name: entry.Name(), Size: info.Size()})
+	}
+	return snapshot, nil
+}
+
+// GetSnapshots returns the snapshots of the session hash, the oldest first. The snapshots of every session are returned when it is empty
+func GetSnapshots(sessionHash string) ([]Snapshot, error) {
+	dir := config.GetString(config.CDockerSnapshotDir)
+	if dir == "" {
+		return nil, ErrSnapshotsDisabled
+	}
+
+	sessionHashes := []string{sessionHash}
+	if sessionHash == "" {
+		entries, err := os.ReadDir(dir)
+		if os.IsNotExist(err) {
+			return []Snapshot{}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		sessionHashes = sessionHashes[:0]
+		for _, entry := range entries {
+			if entry.IsDir() {
+				sessionHashes = append(sessionHashes, entry.Name())
+			}
+		}
+	} else if !filename.Valid(sessionHash) {
+		return []Snapshot{}, nil
+	}
+
+	snapshots := make([]Snapshot, 0)
+	for _, sessionHash := range sessionHashes {
+		entries, err := os.ReadDir(filepath.Join(dir, sessionHash))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				continue
+			}
+			snapshot, err := readSnapshot(filepath.Join(dir, sessionHash, entry.Name()), sessionHash)
+			if err != nil {
+				//Snapshot being written
+				continue
+			}
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		return snapshots[i].Time < snapshots[j].Time
+	})
+	return snapshots, nil
+}
+
+// GetSnapshotFile returns the path of a file of a snapshot
+func GetSnapshotFile(sessionHash string, id string, name string) (string, error) {
+	dir := config.GetString(config.CDockerSnapshotDir)
+	if dir == "" {
+		return "", ErrSnapshotsDisabled
+	}
+	if !filename.Valid(sessionHash) || !filename.Valid(id) || !filename.Valid(name) {
+		return "", ErrSnapshotNotFound
+	}
+
+	file := filepath.Join(dir, sessionHash, id, name)
+	if info, err := os.Stat(file); err != nil || info.IsDir() {
+		return "", ErrSnapshotNotFound
+	}
+	return file, nil
+}
@@ -0,0 +1,96 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/mart123p/ctf-reverseproxy/internal/config"
+)
+
+// suspendResource pauses or stops the containers of an idle session
+func (d *DockerService) suspendResource(ctfId int) {
+	mode := config.GetString(config.CDockerSuspendMode)
+	log.Printf("[Docker] -> Suspending resources %d | Mode: %s", ctfId, mode)
+
+	containers, err := d.listResource(ctfId)
+	if err != nil {
+		log.Printf("Warning: [Docker] -> Could not list the containers of resource %d, %s", ctfId, err.Error())
+		return
+	}
+
+	//Set before the containers change state so that checkState does not remove the resource
+	d.suspended[ctfId] = true
+
+	for _, c := range containers {
+		if c.State != "running" {
+			continue
+		}
+
+		if mode == "stop" {
+			err = d.dockerClient.ContainerStop(context.Background(), c.ID, container.StopOptions{})
+		} else {
+			err = d.dockerClient.ContainerPause(context.Background(), c.ID)
+		}
+		if err != nil {
+			log.Printf("Warning: [Docker] -> Could not suspend the container \"%v\" id: %s, %s", c.Names, c.ID, err.Error())
+		}
+	}
+
+	log.Printf("[Docker] -> Resource %d suspended", ctfId)
+}
+
+// resumeResource starts the containers of a suspended session. Returns false if the resource could not be resumed
+func (d *DockerService) resumeResource(ctfId int) bool {
+	log.Printf("[Docker] -> Resuming resources %d", ctfId)
+
+	containers, err := d.listResource(ctfId)
+	if err != nil {
+		log.Printf("Warning: [Docker] -> Could not list the containers of resource %d, %s", ctfId, err.Error())
+		return false
+	}
+
+	if len(containers) != len(d.compose.project.Services) {
+		log.Printf("Warning: [Docker] -> Resource %d is incomplete and cannot be resumed", ctfId)
+		return false
+	}
+
+	delete(d.suspended, ctfId)
+
+	for _, c := range containers {
+		switch c.State {
+		case "paused":
+			err = d.dockerClient.ContainerUnpause(context.Background(), c.ID)
+		case "exited", "created":
+			err = d.dockerClient.ContainerStart(context.Background(), c.ID, types.ContainerStartOptions{})
+		default:
+			continue
+		}
+		if err != nil {
+			log.Printf("Warning: [Docker] -> Could not resume the container \"%v\" id: %s, %s", c.Names, c.ID, err.Error())
+			return false
+		}
+	}
+
+	log.Printf("[Docker] -> Resource %d resumed", ctfId)
+	return true
+}
+
+// listResource returns all the containers of the resource, including the paused and stopped ones
+func (d *DockerService) listResource(ctfId int) ([]types.Container, error) {
+	containers, err := d.dockerClient.ContainerList(context.Background(), types.ContainerListOptions{All: true})
+	if err != nil {
+		return nil, err
+	}
+
+	ctfIdStr := fmt.Sprintf("%d", ctfId)
+	resource := make([]types.Container, 0)
+	for _, c := range containers {
+		if isCtfResource(c.Labels) && isCtfId(c.Labels, ctfIdStr) {
+			resource = append(resource, c)
+		}
+	}
+	return resource, nil
+}
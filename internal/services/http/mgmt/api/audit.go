@@ -0,0 +1,78 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mart123p/ctf-reverseproxy/internal/services/http/mgmt/audit"
+	"github.com/mart123p/ctf-reverseproxy/internal/services/sessionmanager"
+	"github.com/mart123p/ctf-reverseproxy/pkg/rbody"
+)
+
+// Most recent entries returned when no limit is given
+const defaultAuditLimit = 1000
+
+// parseTime reads a RFC3339 time or a unix timestamp. An empty value is the zero time
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// GetAudit returns the management actions. Filtered with ?from=&to= (RFC3339 or unix time), ?session=id and ?limit=
+func GetAudit(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	from, err := parseTime(query.Get("from"))
+	if err != nil {
+		rbody.JSONError(w, http.StatusBadRequest, fmt.Sprintf("Invalid from \"%s\". Expected a RFC3339 time or a unix timestamp", query.Get("from")))
+		return
+	}
+	to, err := parseTime(query.Get("to"))
+	if err != nil {
+		rbody.JSONError(w, http.StatusBadRequest, fmt.Sprintf("Invalid to \"%s\". Expected a RFC3339 time or a unix timestamp", query.Get("to")))
+		return
+	}
+
+	limit := defaultAuditLimit
+	if value := query.Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 {
+			rbody.JSONError(w, http.StatusBadRequest, "Invalid limit. Expected a positive number")
+			return
+		}
+	}
+
+	selection := audit.Query{From: from, To: to, Limit: limit}
+	if sessionId := query.Get("session"); sessionId != "" {
+		//The entries recorded before a rotation have the hash of a previous key
+		hashes := make(map[string]bool)
+		for _, sessionHash := range sessionmanager.GetHashes(sessionmanager.ResolveOperator(sessionId)) {
+			hashes[sessionHash] = true
+		}
+		selection.Match = func(entry *audit.Entry) bool {
+			return hashes[entry.SessionHash] || entry.Session == sessionId
+		}
+	}
+
+	entries, err := audit.Read(selection)
+	if err == audit.ErrDisabled {
+		rbody.JSONError(w, http.StatusConflict, "The audit log file is not set")
+		return
+	}
+	if err != nil {
+		rbody.JSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	rbody.JSON(w, http.StatusOK, struct {
+		Entries []audit.Entry
+	}{
+		Entries: entries,
+	})
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/mart123p/ctf-reverseproxy/internal/services/http/reverseproxy"
+	"github.com/mart123p/ctf-reverseproxy/pkg/rbody"
+)
+
+// GetCaptures returns the HAR files of the captured traffic. Filtered with ?session=id (?hash=true when it is the hash)
+func GetCaptures(w http.ResponseWriter, r *http.Request) {
+	files := make([]reverseproxy.CaptureFile, 0)
+	for _, sessionHash := range sessionHashes(r) {
+		found, err := reverseproxy.GetCaptures(sessionHash)
+		if err == reverseproxy.ErrCaptureDisabled {
+			rbody.JSONError(w, http.StatusConflict, "The capture directory is not set")
+			return
+		}
+		if err != nil {
+			rbody.JSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		files = append(files, found...)
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].Name < files[j].Name
+	})
+
+	rbody.JSON(w, http.StatusOK, struct {
+		Captures []reverseproxy.CaptureFile
+	}{
+		Captures: files,
+	})
+}
+
+// GetCaptureFile downloads a HAR file. The file being written ends after its last complete entry
+func GetCaptureFile(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	file, size, err := reverseproxy.OpenCapture(vars["sessionHash"], vars["file"])
+	if err == reverseproxy.ErrCaptureDisabled {
+		rbody.JSONError(w, http.StatusConflict, "The capture directory is not set")
+		return
+	}
+	if err == reverseproxy.ErrCaptureNotFound {
+		rbody.JSONError(w, http.StatusNotFound, "Capture not found")
+		return
+	}
+	if err != nil {
+		rbody.JSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer file.Close()
+
+	//Not application/json so the file is not wrapped in an envelope
+	w.Header().Set("Content-Type", "application/har+json")
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s-%s\"", vars["sessionHash"], vars["file"]))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+
+	io.Copy(w, file)
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mart123p/ctf-reverseproxy/internal/services/http/mgmt/events"
+	"github.com/mart123p/ctf-reverseproxy/internal/services/sessionmanager"
+	"github.com/mart123p/ctf-reverseproxy/pkg/rbody"
+)
+
+// Interval of the comments keeping the stream open through the proxies
+const keepAliveInterval = 15 * time.Second
+
+// GetEvents streams the activity of the proxy as server-sent events. Filtered with ?type=a,b and ?session=id
+func GetEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		rbody.JSONError(w, http.StatusInternalServerError, "Streaming is not supported")
+		return
+	}
+
+	filter := events.Filter{Types: make(map[string]bool)}
+	if types := r.URL.Query().Get("type"); types != "" {
+		for _, eventType := range strings.Split(types, ",") {
+			filter.Types[strings.TrimSpace(eventType)] = true
+		}
+	}
+	if sessionId := r.URL.Query().Get("session"); sessionId != "" {
+		filter.SessionHash = sessionmanager.GetHash(sessionmanager.ResolveOperator(sessionId))
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	client := events.Subscribe(filter)
+	defer events.Unsubscribe(client)
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-client.Done:
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case event := <-client.Events:
+			//The client missed events because it did not keep up
+			if dropped := events.Dropped(client); dropped > 0 {
+				fmt.Fprintf(w, "event: dropped\ndata: {\"Dropped\":%d}\n\n", dropped)
+			}
+
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Event, data)
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,186 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/mart123p/ctf-reverseproxy/internal/services/docker"
+	"github.com/mart123p/ctf-reverseproxy/internal/services/http/mgmt/audit"
+	"github.com/mart123p/ctf-reverseproxy/internal/services/sessionmanager"
+	"github.com/mart123p/ctf-reverseproxy/pkg/rbody"
+)
+
+// Command run when none is given
+const defaultExecCmd = "/bin/sh"
+
+// Input of the terminal kept in the audit log
+const maxTranscriptSize = 64 * 1024
+
+// The key is sent in a header so the origin of the browser is not trusted for authentication
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}
+
+// ExecMessage is sent by the client in a text frame. The binary frames are written as is to the terminal
+type ExecMessage struct {
+	Type string //input or resize
+	Data string //Input of the terminal
+	Cols uint
+	Rows uint
+}
+
+// transcript keeps the beginning of the input sent to the terminal
+type transcript struct {
+	bytes.Buffer
+	truncated bool
+}
+
+func (t *transcript) add(data []byte) {
+	if left := maxTranscriptSize - t.Len(); len(data) > left {
+		data = data[:left]
+		t.truncated = true
+	}
+	t.Write(data)
+}
+
+// GetSessionExec opens a terminal in a container of the session instance over a websocket. Options ?container= (main service by default) and ?cmd=/bin/sh
+func GetSessionExec(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	session, ok := sessionmanager.GetSessions()[getSessionHash(r)]
+	if !ok {
+		rbody.JSONError(w, http.StatusNotFound, "Session not found")
+		return
+	}
+
+	cmd := strings.Fields(query.Get("cmd"))
+	if len(cmd) == 0 {
+		cmd = []string{defaultExecCmd}
+	}
+
+	//The exec must outlive the request context once the connection is hijacked
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	exec, err := docker.Exec(ctx, session.Addr, query.Get("container"), cmd)
+	if err == docker.ErrInstanceNotFound {
+		rbody.JSONError(w, http.StatusNotFound, "Instance not found")
+		return
+	}
+	if err == docker.ErrContainerNotFound {
+		rbody.JSONError(w, http.StatusNotFound, "Container not found")
+		return
+	}
+	if err != nil {
+		rbody.JSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer exec.Close()
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		//The upgrader already replied
+		log.Printf("Warning: [MgmtServer] -> Could not upgrade the terminal connection, %s", err)
+		return
+	}
+	defer conn.Close()
+
+	entry := audit.NewEntry(r)
+	entry.Instance = session.Addr
+	entry.Params["container"] = exec.Container
+	entry.Params["cmd"] = strings.Join(cmd, " ")
+	entry.Status = http.StatusSwitchingProtocols
+	entry.Result = "opened"
+	audit.Record(entry)
+	log.Printf("[MgmtServer] -> Terminal opened | Actor: %s | Session: %s | Container: %s", entry.Actor, entry.SessionHash, exec.Container)
+	start := time.Now()
+
+	var writeLock sync.Mutex
+	writeMessage := func(messageType int, data []byte) error {
+		writeLock.Lock()
+		defer writeLock.Unlock()
+		return conn.WriteMessage(messageType, data)
+	}
+
+	//Output of the terminal
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buffer := make([]byte, 32*1024)
+		for {
+			n, err := exec.Read(buffer)
+			if n > 0 {
+				if writeMessage(websocket.BinaryMessage, buffer[:n]) != nil {
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	//Input of the terminal. Stops when the client or the command exits
+	var input transcript
+	go func() {
+		<-done
+		conn.Close()
+	}()
+	for {
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			break
+		}
+
+		if messageType == websocket.TextMessage {
+			var message ExecMessage
+			if err := json.Unmarshal(data, &message); err != nil {
+				continue
+			}
+			if message.Type == "resize" {
+				if message.Cols > 0 && message.Rows > 0 {
+					exec.Resize(ctx, message.Rows, message.Cols)
+				}
+				continue
+			}
+			data = []byte(message.Data)
+		}
+
+		input.add(data)
+		if _, err := exec.Write(data); err != nil && err != io.EOF {
+			break
+		}
+	}
+
+	exec.Close()
+	<-done
+
+	exitCode := exec.ExitCode(ctx)
+	writeMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, fmt.Sprintf("exit code %d", exitCode)))
+
+	entry = audit.NewEntry(r)
+	entry.Instance = session.Addr
+	entry.Params["container"] = exec.Container
+	entry.Params["cmd"] = strings.Join(cmd, " ")
+	entry.Params["duration"] = time.Since(start).Round(time.Second).String()
+	entry.Params["exit_code"] = exitCode
+	entry.Params["input"] = input.String()
+	if input.truncated {
+		entry.Params["input_truncated"] = true
+	}
+	entry.Status = http.StatusSwitchingProtocols
+	entry.Result = "closed"
+	audit.Record(entry)
+	log.Printf("[MgmtServer] -> Terminal closed | Actor: %s | Session: %s | Container: %s | Exit code: %d", entry.Actor, entry.SessionHash, exec.Container, exitCode)
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mart123p/ctf-reverseproxy/internal/services/docker"
+	"github.com/mart123p/ctf-reverseproxy/internal/services/sessionmanager"
+	"github.com/mart123p/ctf-reverseproxy/pkg/rbody"
+)
+
+// Status of the instances found by docker that the session manager does not use
+const instanceUnmanaged = "unmanaged"
+
+type InstanceResponse struct {
+	docker.Instance
+	Status   string
+	Sessions []string
+}
+
+func newInstanceResponse(instance docker.Instance, statuses map[string]sessionmanager.InstanceStatus) InstanceResponse {
+	status, ok := statuses[instance.Addr]
+	if !ok {
+		status = sessionmanager.InstanceStatus{Status: instanceUnmanaged, Sessions: make([]string, 0)}
+	}
+	return InstanceResponse{
+		Instance: instance,
+		Status:   status.Status,
+		Sessions: status.Sessions,
+	}
+}
+
+// getInstance returns the instance of the ctf id in the path
+func getInstance(w http.ResponseWriter, r *http.Request) (docker.Instance, bool) {
+	vars := mux.Vars(r)
+	ctfId, err := strconv.Atoi(vars["ctfId"])
+	if err != nil {
+		rbody.JSONError(w, http.StatusBadRequest, "Invalid ctf id. Expected a number")
+		return docker.Instance{}, false
+	}
+
+	instance, ok := docker.GetInstance(ctfId)
+	if !ok {
+		rbody.JSONError(w, http.StatusNotFound, "Instance not found")
+		return docker.Instance{}, false
+	}
+	return instance, true
+}
+
+func GetInstances(w http.ResponseWriter, r *http.Request) {
+	instances, updatedOn := docker.GetInstances()
+	statuses := sessionmanager.GetInstanceStatus()
+
+	response := make([]InstanceResponse, 0, len(instances))
+	for _, instance := range instances {
+		response = append(response, newInstanceResponse(instance, statuses))
+	}
+
+	rbody.JSON(w, http.StatusOK, struct {
+		Instances []InstanceResponse
+		UpdatedOn int64
+	}{
+		Instances: response,
+		UpdatedOn: unixOrZero(updatedOn),
+	})
+}
+
+func GetInstance(w http.ResponseWriter, r *http.Request) {
+	instance, ok := getInstance(w, r)
+	if !ok {
+		return
+	}
+
+	rbody.JSON(w, http.StatusOK, struct {
+		Instance InstanceResponse
+	}{
+		Instance: newInstanceResponse(instance, sessionmanager.GetInstanceStatus()),
+	})
+}
+
+func DeleteInstance(w http.ResponseWriter, r *http.Request) {
+	instance, ok := getInstance(w, r)
+	if !ok {
+		return
+	}
+
+	if !sessionmanager.RecycleInstance(instance.Addr) {
+		rbody.JSONError(w, http.StatusConflict, "The instance is not used by the proxy or is already being removed")
+		return
+	}
+	rbody.JSON(w, http.StatusOK, "Instance recycled")
+}
+
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
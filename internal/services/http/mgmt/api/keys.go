@@ -0,0 +1,39 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/mart123p/ctf-reverseproxy/internal/services/sessionmanager"
+	"github.com/mart123p/ctf-reverseproxy/pkg/rbody"
+)
+
+type RotateRequest struct {
+	Key string //New key of the session hashes. A random key is generated when empty
+}
+
+func GetKeys(w http.ResponseWriter, r *http.Request) {
+	rbody.JSON(w, http.StatusOK, struct {
+		Keys sessionmanager.KeyInfo
+	}{
+		Keys: sessionmanager.GetKeys(),
+	})
+}
+
+func PostKeysRotate(w http.ResponseWriter, r *http.Request) {
+	//The body is optional
+	var request RotateRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil && err != io.EOF {
+		rbody.JSONError(w, http.StatusBadRequest, "Invalid body. Expected {\"Key\": \"...\"}")
+		return
+	}
+
+	rbody.JSON(w, http.StatusOK, struct {
+		Keys    sessionmanager.KeyInfo
+		Message string
+	}{
+		Keys:    sessionmanager.RotateKey([]byte(request.Key)),
+		Message: "Session key rotated",
+	})
+}
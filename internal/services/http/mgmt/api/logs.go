@@ -0,0 +1,75 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/mart123p/ctf-reverseproxy/internal/services/docker"
+	"github.com/mart123p/ctf-reverseproxy/internal/services/sessionmanager"
+	"github.com/mart123p/ctf-reverseproxy/pkg/rbody"
+)
+
+// Lines returned per container when no tail is given
+const defaultLogsTail = "100"
+
+// GetSessionLogs returns the logs of the containers of the session instance as text. Refused for the shared instances. Options ?tail=100|all, ?since=, ?follow=true and ?timestamps=true
+func GetSessionLogs(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	options := docker.LogOptions{
+		Tail:       query.Get("tail"),
+		Since:      query.Get("since"),
+		Follow:     query.Get("follow") == "true",
+		Timestamps: query.Get("timestamps") == "true",
+	}
+	if options.Tail == "" {
+		options.Tail = defaultLogsTail
+	}
+	if tail, err := strconv.Atoi(options.Tail); options.Tail != "all" && (err != nil || tail < 0) {
+		rbody.JSONError(w, http.StatusBadRequest, "Invalid tail. Expected a positive number or all")
+		return
+	}
+
+	session, ok := sessionmanager.GetSessions()[getSessionHash(r)]
+	if !ok {
+		rbody.JSONError(w, http.StatusNotFound, "Session not found")
+		return
+	}
+
+	//The logs of a shared instance contain the traffic of the other sessions
+	if sessionmanager.GetInstanceStatus()[session.Addr].Status == sessionmanager.InstanceShared {
+		rbody.JSONError(w, http.StatusConflict, "The instance of the session is shared with other sessions")
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if options.Follow && !ok {
+		rbody.JSONError(w, http.StatusInternalServerError, "Streaming is not supported")
+		return
+	}
+	flush := func() {}
+	if options.Follow {
+		flush = flusher.Flush
+	}
+
+	logs, err := docker.GetInstanceLogs(r.Context(), session.Addr)
+	if err == docker.ErrInstanceNotFound {
+		rbody.JSONError(w, http.StatusNotFound, "Instance not found")
+		return
+	}
+	if err != nil {
+		rbody.JSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if options.Follow {
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+	}
+	w.WriteHeader(http.StatusOK)
+	flush()
+
+	logs.Stream(r.Context(), options, w, flush)
+}
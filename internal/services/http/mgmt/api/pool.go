@@ -0,0 +1,37 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/mart123p/ctf-reverseproxy/internal/services/sessionmanager"
+	"github.com/mart123p/ctf-reverseproxy/pkg/rbody"
+)
+
+type PoolRequest struct {
+	Size int
+}
+
+func GetPool(w http.ResponseWriter, r *http.Request) {
+	rbody.JSON(w, http.StatusOK, struct {
+		Pool sessionmanager.PoolState
+	}{
+		Pool: sessionmanager.GetPool(),
+	})
+}
+
+func PutPool(w http.ResponseWriter, r *http.Request) {
+	var request PoolRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Size < 0 {
+		rbody.JSONError(w, http.StatusBadRequest, "Invalid body. Expected {\"Size\": <positive number>}")
+		return
+	}
+
+	rbody.JSON(w, http.StatusOK, struct {
+		Pool    sessionmanager.PoolState
+		Message string
+	}{
+		Pool:    sessionmanager.ResizePool(request.Size),
+		Message: "Pool resized",
+	})
+}
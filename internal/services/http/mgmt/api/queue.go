@@ -0,0 +1,64 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/mart123p/ctf-reverseproxy/internal/services/sessionmanager"
+	"github.com/mart123p/ctf-reverseproxy/pkg/rbody"
+)
+
+type PriorityRequest struct {
+	Priority int
+}
+
+func GetQueue(w http.ResponseWriter, r *http.Request) {
+	rbody.JSON(w, http.StatusOK, struct {
+		Queue []sessionmanager.QueueEntry
+	}{
+		Queue: sessionmanager.GetQueue(),
+	})
+}
+
+func GetPriorities(w http.ResponseWriter, r *http.Request) {
+	rbody.JSON(w, http.StatusOK, struct {
+		Priorities map[string]int
+	}{
+		Priorities: sessionmanager.GetPriorities(),
+	})
+}
+
+func PutPriority(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	sessionId := sessionmanager.ResolveOperator(vars["id"])
+
+	var request PriorityRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		rbody.JSONError(w, http.StatusBadRequest, "Invalid body. Expected {\"Priority\": 0}")
+		return
+	}
+
+	sessionmanager.SetPriority(sessionId, request.Priority)
+
+	rbody.JSON(w, http.StatusOK, struct {
+		SessionId string
+		Priority  int
+		Message   string
+	}{
+		SessionId: sessionId,
+		Priority:  request.Priority,
+		Message:   "Priority updated",
+	})
+}
+
+func DeletePriority(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	sessionId := sessionmanager.ResolveOperator(vars["id"])
+
+	if sessionmanager.DeletePriority(sessionId) {
+		rbody.JSON(w, http.StatusOK, "Priority deleted")
+		return
+	}
+	rbody.JSONError(w, http.StatusNotFound, "Priority not found")
+}
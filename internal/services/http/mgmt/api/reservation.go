@@ -0,0 +1,60 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/mart123p/ctf-reverseproxy/internal/services/sessionmanager"
+	"github.com/mart123p/ctf-reverseproxy/pkg/rbody"
+)
+
+type ReservationRequest struct {
+	Sessions []string
+}
+
+func GetReservations(w http.ResponseWriter, r *http.Request) {
+	rbody.JSON(w, http.StatusOK, struct {
+		Reservations []sessionmanager.Reservation
+	}{
+		Reservations: sessionmanager.GetReservations(),
+	})
+}
+
+func PostReservations(w http.ResponseWriter, r *http.Request) {
+	var request ReservationRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || len(request.Sessions) == 0 {
+		rbody.JSONError(w, http.StatusBadRequest, "Invalid body. Expected {\"Sessions\": [...]}")
+		return
+	}
+
+	sessionIds := make([]string, 0, len(request.Sessions))
+	for _, sessionId := range request.Sessions {
+		sessionIds = append(sessionIds, sessionmanager.ResolveOperator(sessionId))
+	}
+
+	reservations, err := sessionmanager.ReserveSessions(sessionIds)
+	if err != nil {
+		rbody.JSONError(w, http.StatusConflict, err.Error())
+		return
+	}
+
+	rbody.JSON(w, http.StatusCreated, struct {
+		Reservations []sessionmanager.Reservation
+		Message      string
+	}{
+		Reservations: reservations,
+		Message:      "Instances reserved",
+	})
+}
+
+func DeleteReservation(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	sessionHash := sessionmanager.GetHash(sessionmanager.ResolveOperator(vars["id"]))
+
+	if sessionmanager.ReleaseSession(sessionHash) {
+		rbody.JSON(w, http.StatusOK, "Reservation released")
+		return
+	}
+	rbody.JSONError(w, http.StatusNotFound, "Reservation not found")
+}
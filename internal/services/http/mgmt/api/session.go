@@ -1,6 +1,9 @@
 package api
 
 import (
+	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
 
 	"github.com/gorilla/mux"
@@ -24,9 +27,17 @@ func GetSession(w http.ResponseWriter, r *http.Request) {
 
 func PostSession(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	sessionId := vars["id"]
+	sessionId := sessionmanager.ResolveOperator(vars["id"])
 	sessionHash := sessionmanager.GetHash(sessionId)
-	addr := sessionmanager.MatchSessionContainer(sessionId, sessionHash)
+
+	//The body is optional
+	options, err := decodeSessionOptions(r)
+	if err != nil && err != io.EOF {
+		rbody.JSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	addr := sessionmanager.MatchSessionWithOptions(sessionId, sessionHash, options).Addr
 
 	rbody.JSON(w, http.StatusCreated, struct {
 		Session SessionResponse
@@ -40,14 +51,125 @@ func PostSession(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func DeleteSession(w http.ResponseWriter, r *http.Request) {
+func PatchSession(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	sessionId := vars["id"]
+	sessionId := sessionmanager.ResolveOperator(vars["id"])
 	sessionHash := sessionmanager.GetHash(sessionId)
 
+	options, err := decodeSessionOptions(r)
+	if err != nil {
+		rbody.JSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	session := sessionmanager.UpdateSession(sessionHash, options)
+	if session == nil {
+		rbody.JSONError(w, http.StatusNotFound, "Session not found")
+		return
+	}
+
+	rbody.JSON(w, http.StatusOK, struct {
+		Session sessionmanager.SessionState
+		Message string
+	}{
+		Session: *session,
+		Message: "Session updated",
+	})
+}
+
+// decodeSessionOptions reads the timeouts and the metadata of a session from the body
+func decodeSessionOptions(r *http.Request) (*sessionmanager.SessionOptions, error) {
+	var options sessionmanager.SessionOptions
+	if err := json.NewDecoder(r.Body).Decode(&options); err != nil {
+		if err == io.EOF {
+			return nil, err
+		}
+		return nil, errors.New("Invalid body. Expected {\"Timeout\": <seconds>, \"MaxLifetime\": <seconds>, \"TeamName\": \"\", \"Notes\": \"\"}")
+	}
+
+	if (options.Timeout != nil && *options.Timeout < 0) || (options.MaxLifetime != nil && *options.MaxLifetime < 0) {
+		return nil, errors.New("Timeout and MaxLifetime must be positive")
+	}
+	return &options, nil
+}
+
+// getSessionHash returns the hash of the session in the path. With ?hash=true the path already contains the hash
+func getSessionHash(r *http.Request) string {
+	vars := mux.Vars(r)
+	if r.URL.Query().Get("hash") == "true" {
+		return vars["id"]
+	}
+	return sessionmanager.GetHash(sessionmanager.ResolveOperator(vars["id"]))
+}
+
+// sessionHashes returns the hashes of ?session=id with the active and previous keys, or the hash itself with ?hash=true.
+// An empty hash selects every session
+func sessionHashes(r *http.Request) []string {
+	query := r.URL.Query()
+
+	sessionId := query.Get("session")
+	if sessionId == "" || query.Get("hash") == "true" {
+		return []string{sessionId}
+	}
+	return sessionmanager.GetHashes(sessionmanager.ResolveOperator(sessionId))
+}
+
+func DeleteSession(w http.ResponseWriter, r *http.Request) {
+	sessionHash := getSessionHash(r)
+
 	if sessionmanager.DeleteSession(sessionHash) {
 		rbody.JSON(w, http.StatusOK, "Session deleted")
 		return
 	}
 	rbody.JSONError(w, http.StatusNotFound, "Session not found")
 }
+
+type ExtendRequest struct {
+	Seconds int64
+}
+
+func PostSessionExtend(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	sessionId := sessionmanager.ResolveOperator(vars["id"])
+	sessionHash := sessionmanager.GetHash(sessionId)
+
+	var request ExtendRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Seconds <= 0 {
+		rbody.JSONError(w, http.StatusBadRequest, "Invalid body. Expected {\"Seconds\": <positive number>}")
+		return
+	}
+
+	session := sessionmanager.ExtendSession(sessionHash, request.Seconds)
+	if session == nil {
+		rbody.JSONError(w, http.StatusNotFound, "Session not found")
+		return
+	}
+	if session.EndsOn == 0 {
+		rbody.JSONError(w, http.StatusConflict, "The session has no maximum lifetime")
+		return
+	}
+
+	rbody.JSON(w, http.StatusOK, struct {
+		Session sessionmanager.SessionState
+		Message string
+	}{
+		Session: *session,
+		Message: "Session extended",
+	})
+}
+
+func PostSessionRecycle(w http.ResponseWriter, r *http.Request) {
+	sessionHash := getSessionHash(r)
+
+	session, ok := sessionmanager.GetSessions()[sessionHash]
+	if !ok {
+		rbody.JSONError(w, http.StatusNotFound, "Session not found")
+		return
+	}
+
+	if sessionmanager.RecycleSession(sessionHash, session.Addr) {
+		rbody.JSON(w, http.StatusOK, "Session recycled")
+		return
+	}
+	rbody.JSONError(w, http.StatusNotFound, "Session not found")
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/mart123p/ctf-reverseproxy/internal/services/docker"
+	"github.com/mart123p/ctf-reverseproxy/pkg/rbody"
+)
+
+// GetSnapshots returns the snapshots taken when the instances were removed. Filtered with ?session=id (?hash=true when it is the hash)
+func GetSnapshots(w http.ResponseWriter, r *http.Request) {
+	snapshots := make([]docker.Snapshot, 0)
+	for _, sessionHash := range sessionHashes(r) {
+		found, err := docker.GetSnapshots(sessionHash)
+		if err == docker.ErrSnapshotsDisabled {
+			rbody.JSONError(w, http.StatusConflict, "The snapshot directory is not set")
+			return
+		}
+		if err != nil {
+			rbody.JSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		snapshots = append(snapshots, found...)
+	}
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		return snapshots[i].Time < snapshots[j].Time
+	})
+
+	rbody.JSON(w, http.StatusOK, struct {
+		Snapshots []docker.Snapshot
+	}{
+		Snapshots: snapshots,
+	})
+}
+
+// GetSnapshotFile downloads a file of a snapshot. The diffs are text, the exported files are gzipped tar archives
+func GetSnapshotFile(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	path, err := docker.GetSnapshotFile(vars["sessionHash"], vars["snapshot"], vars["file"])
+	if err == docker.ErrSnapshotsDisabled {
+		rbody.JSONError(w, http.StatusConflict, "The snapshot directory is not set")
+		return
+	}
+	if err != nil {
+		rbody.JSONError(w, http.StatusNotFound, "Snapshot file not found")
+		return
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		rbody.JSONError(w, http.StatusNotFound, "Snapshot file not found")
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		rbody.JSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	name := filepath.Base(path)
+	switch {
+	case strings.HasSuffix(name, ".diff"):
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	case strings.HasSuffix(name, ".tar.gz"):
+		w.Header().Set("Content-Type", "application/gzip")
+	default:
+		w.Header().Set("Content-Type", "application/octet-stream")
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s-%s\"", vars["snapshot"], name))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	http.ServeContent(w, r, name, info.ModTime(), file)
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/mart123p/ctf-reverseproxy/internal/services/sessionmanager"
+	"github.com/mart123p/ctf-reverseproxy/pkg/rbody"
+)
+
+type TeamRequest struct {
+	Members []string
+}
+
+func GetTeams(w http.ResponseWriter, r *http.Request) {
+	rbody.JSON(w, http.StatusOK, struct {
+		Teams map[string][]string
+	}{
+		Teams: sessionmanager.GetTeams(),
+	})
+}
+
+func PutTeam(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	team := vars["team"]
+
+	var request TeamRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		rbody.JSONError(w, http.StatusBadRequest, "Invalid body. Expected {\"Members\": [...]}")
+		return
+	}
+
+	sessionmanager.SetTeam(team, request.Members)
+
+	rbody.JSON(w, http.StatusOK, struct {
+		Team    string
+		Members []string
+		Message string
+	}{
+		Team:    team,
+		Members: request.Members,
+		Message: "Team updated",
+	})
+}
+
+func DeleteTeam(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	team := vars["team"]
+
+	if sessionmanager.DeleteTeam(team) {
+		rbody.JSON(w, http.StatusOK, "Team deleted")
+		return
+	}
+	rbody.JSONError(w, http.StatusNotFound, "Team not found")
+}
@@ -0,0 +1,223 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"time"
+
+	"github.com/mart123p/ctf-reverseproxy/internal/config"
+	"github.com/mart123p/ctf-reverseproxy/pkg/rotate"
+)
+
+var ErrDisabled = errors.New("the audit log file is not set")
+
+// Largest line read back from the audit log
+const maxLineSize = 1024 * 1024
+
+// Size of the chunks read from the end of the audit log
+const readChunkSize = 64 * 1024
+
+// Entry records a management action
+type Entry struct {
+	Time        string                 `json:"time"`
+	Actor       string                 `json:"actor"` //Name of the management key
+	Client      string                 `json:"client"`
+	Action      string                 `json:"action"`            //Method and route. Ex: DELETE /session/{id}
+	Path        string                 `json:"path"`              //The session ids are redacted
+	Session     string                 `json:"session,omitempty"` //Team or hash as given in the path. The session ids are not recorded
+	SessionHash string                 `json:"session_hash,omitempty"`
+	Instance    string                 `json:"instance,omitempty"`
+	Params      map[string]interface{} `json:"params,omitempty"` //Body, query and path parameters
+	Status      int                    `json:"status"`
+	Result      string                 `json:"result"` //ok or the error returned
+}
+
+// Logger appends the entries to the audit log
+type Logger struct {
+	out *rotate.Writer
+}
+
+var singleton *Logger
+
+// Open the audit log file. When no file is configured the entries are sent to the standard logger
+func Open() *Logger {
+	l := &Logger{}
+	singleton = l
+
+	path := config.GetString(config.CMgmtAuditFile)
+	if path == "" {
+		return l
+	}
+
+	maxSize := config.GetInt64(config.CMgmtAuditMaxSize) * 1024 * 1024
+	out, err := rotate.Open(path, maxSize, config.GetInt(config.CMgmtAuditMaxBackups))
+	if err != nil {
+		log.Fatalf("[MgmtServer] -> Could not open the audit log \"%s\", %s", path, err)
+	}
+	l.out = out
+	log.Printf("[MgmtServer] -> Audit log written to \"%s\"", path)
+	return l
+}
+
+func (l *Logger) Close() {
+	if l.out != nil {
+		l.out.Close()
+	}
+}
+
+func (l *Logger) write(entry *Entry) {
+	if l.out == nil {
+		log.Printf("[Audit] -> %s | Actor: %s | Client: %s | Session: %s | Instance: %s | Status: %d | Result: %s", entry.Action, entry.Actor, entry.Client, entry.SessionHash, entry.Instance, entry.Status, entry.Result)
+		return
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("Warning: [MgmtServer] -> Could not marshal audit entry, %s", err)
+		return
+	}
+	line = append(line, '\n')
+
+	if _, err := l.out.Write(line); err != nil {
+		log.Printf("Warning: [MgmtServer] -> Could not write audit entry, %s", err)
+	}
+}
+
+// Query selects the entries returned by Read
+type Query struct {
+	From  time.Time               //Not checked when zero
+	To    time.Time               //Not checked when zero
+	Match func(entry *Entry) bool //Every entry matches when nil
+	Limit int                     //Most recent entries returned. 0 for no limit
+}
+
+// read returns the entries selected by the query, the oldest first. The files are read from the end and the read stops at the limit
+func (l *Logger) read(query Query) ([]Entry, error) {
+	if l.out == nil {
+		return nil, ErrDisabled
+	}
+
+	entries := make([]Entry, 0)
+	files := l.out.Files()
+	done := false
+	for i := len(files) - 1; i >= 0 && !done; i-- {
+		file, err := os.Open(files[i])
+		if os.IsNotExist(err) {
+			//Rotated while reading
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		err = readReverse(file, func(line []byte) bool {
+			var entry Entry
+			if err := json.Unmarshal(line, &entry); err != nil {
+				return true
+			}
+			t, err := time.Parse(time.RFC3339Nano, entry.Time)
+			if err != nil || (!query.To.IsZero() && t.After(query.To)) {
+				return true
+			}
+			//The entries are written in order. The older ones are all before from
+			if !query.From.IsZero() && t.Before(query.From) {
+				done = true
+				return false
+			}
+			if query.Match != nil && !query.Match(&entry) {
+				return true
+			}
+
+			entries = append(entries, entry)
+			if query.Limit > 0 && len(entries) >= query.Limit {
+				done = true
+				return false
+			}
+			return true
+		})
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries, nil
+}
+
+// readReverse calls fn with the lines of the file, the last one first, until it returns false. The lines longer than maxLineSize are skipped
+func readReverse(file *os.File, fn func(line []byte) bool) error {
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	offset := info.Size()
+	var carry []byte //Start of the line continued in the chunk read before
+	skipping := false
+	for offset > 0 {
+		size := int64(readChunkSize)
+		if offset < size {
+			size = offset
+		}
+		offset -= size
+
+		data := make([]byte, size, size+int64(len(carry)))
+		if _, err := file.ReadAt(data, offset); err != nil {
+			return err
+		}
+		data = append(data, carry...)
+
+		for {
+			index := bytes.LastIndexByte(data, '\n')
+			if index == -1 {
+				break
+			}
+			line := data[index+1:]
+			data = data[:index]
+
+			if skipping {
+				skipping = false
+				continue
+			}
+			if len(line) > 0 && len(line) <= maxLineSize && !fn(line) {
+				return nil
+			}
+		}
+
+		carry = data
+		if len(carry) > maxLineSize {
+			carry = nil
+			skipping = true
+		}
+	}
+
+	if len(carry) > 0 && !skipping {
+		fn(carry)
+	}
+	return nil
+}
+
+// Record appends the entry to the audit log. The time is set when empty
+func Record(entry *Entry) {
+	if entry.Time == "" {
+		entry.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	}
+	if singleton == nil {
+		return
+	}
+	singleton.write(entry)
+}
+
+// Read returns the entries selected by the query, the oldest first
+func Read(query Query) ([]Entry, error) {
+	if singleton == nil {
+		return nil, ErrDisabled
+	}
+	return singleton.read(query)
+}
@@ -0,0 +1,196 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/mart123p/ctf-reverseproxy/internal/services/http/mgmt/middleware"
+	"github.com/mart123p/ctf-reverseproxy/internal/services/sessionmanager"
+)
+
+// Largest body kept in the parameters of an entry
+const maxBodySize = 64 * 1024
+
+// Value written in place of the redacted parameters
+const redactedValue = "[redacted]"
+
+// Parameters never written in the audit log. Matched in lower case against the names of the parameters.
+// They carry the management keys, the session ids of the players and secrets
+var redacted = []string{"key", "members", "sessions", "secret", "token", "password", "jwt", "authorization"}
+
+func isRedacted(name string) bool {
+	name = strings.ToLower(name)
+	for _, fragment := range redacted {
+		if strings.Contains(name, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+// resultWriter keeps the status and the error returned by the handler
+type resultWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *resultWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *resultWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.status >= http.StatusBadRequest && w.body.Len() < maxBodySize {
+		w.body.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// result returns ok or the error message written by the handler
+func (w *resultWriter) result() string {
+	if w.status < http.StatusBadRequest {
+		return "ok"
+	}
+
+	var response struct {
+		Error string
+	}
+	if err := json.Unmarshal(w.body.Bytes(), &response); err == nil && response.Error != "" {
+		return response.Error
+	}
+	return http.StatusText(w.status)
+}
+
+// Middleware records the requests changing the state of the proxy. The reads are not recorded
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		entry := newEntry(r)
+
+		rw := resultWriter{ResponseWriter: w}
+		next.ServeHTTP(&rw, r)
+
+		//The actor is known once the key is checked
+		entry.Actor = middleware.KeyName(r)
+		entry.Status = rw.status
+		if entry.Status == 0 {
+			entry.Status = http.StatusOK
+		}
+		entry.Result = rw.result()
+		Record(entry)
+	})
+}
+
+// NewEntry describes a request recorded by its handler. Used by the reads that must be recorded
+func NewEntry(r *http.Request) *Entry {
+	entry := newEntry(r)
+	entry.Actor = middleware.KeyName(r)
+	return entry
+}
+
+// newEntry describes the request. The body is read and given back to the handler
+func newEntry(r *http.Request) *Entry {
+	entry := &Entry{
+		Client: r.RemoteAddr,
+		Action: r.Method + " " + r.URL.Path,
+		Path:   r.URL.RequestURI(),
+		Params: make(map[string]interface{}),
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		entry.Client = host
+	}
+
+	if route := mux.CurrentRoute(r); route != nil {
+		if template, err := route.GetPathTemplate(); err == nil {
+			entry.Action = r.Method + " " + strings.TrimPrefix(template, middleware.APIPrefix)
+		}
+	}
+
+	vars := mux.Vars(r)
+	pathVars := make([]string, 0, len(vars)*2)
+	for name, value := range vars {
+		pathValue := value
+		switch name {
+		case "id":
+			//Only the hash of a session id is recorded. The hashes and the teams are not secret
+			if r.URL.Query().Get("hash") == "true" {
+				entry.Session = value
+				entry.SessionHash = value
+			} else {
+				if strings.HasPrefix(value, "team:") {
+					entry.Session = value
+				} else {
+					pathValue = redactedValue
+				}
+				entry.SessionHash = sessionmanager.GetHash(sessionmanager.ResolveOperator(value))
+			}
+		case "ctfId":
+			entry.Instance = value
+		default:
+			entry.Params[name] = value
+		}
+		pathVars = append(pathVars, name, pathValue)
+	}
+
+	for name, values := range r.URL.Query() {
+		entry.Params[name] = strings.Join(values, ",")
+	}
+	entry.Path = redactedPath(r, pathVars)
+
+	if r.Body != nil {
+		body, _ := io.ReadAll(io.LimitReader(r.Body, maxBodySize))
+		r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+
+		var fields map[string]interface{}
+		if err := json.Unmarshal(body, &fields); err == nil {
+			for name, value := range fields {
+				entry.Params[name] = value
+			}
+		} else if len(bytes.TrimSpace(body)) > 0 {
+			//Could be a token sent by mistake
+			entry.Params["body"] = fmt.Sprintf("[%d bytes]", len(body))
+		}
+	}
+
+	for name := range entry.Params {
+		if isRedacted(name) {
+			entry.Params[name] = redactedValue
+		}
+	}
+	return entry
+}
+
+// redactedPath rebuilds the path of the request from its route with the redacted path variables and query parameters
+func redactedPath(r *http.Request, pathVars []string) string {
+	path := r.URL.Path
+	if route := mux.CurrentRoute(r); route != nil {
+		if u, err := route.URLPath(pathVars...); err == nil {
+			path = u.Path
+		}
+	}
+
+	query := r.URL.Query()
+	for name := range query {
+		if isRedacted(name) {
+			query.Set(name, redactedValue)
+		}
+	}
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	return path
+}
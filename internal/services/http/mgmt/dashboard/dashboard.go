@@ -0,0 +1,34 @@
+package dashboard
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// Prefix of the dashboard on the management server
+const Prefix = "/dashboard"
+
+//go:embed static
+var static embed.FS
+
+// Handler serves the dashboard assets. The assets are public, the data is loaded with the management key entered by the operator
+func Handler() http.Handler {
+	assets, err := fs.Sub(static, "static")
+	if err != nil {
+		panic(err)
+	}
+
+	files := http.StripPrefix(Prefix, http.FileServer(http.FS(assets)))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		//The assets are relative to the directory
+		if r.URL.Path == Prefix {
+			http.Redirect(w, r, Prefix+"/", http.StatusMovedPermanently)
+			return
+		}
+
+		w.Header().Set("Content-Security-Policy", "default-src 'self'; frame-ancestors 'none'")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		files.ServeHTTP(w, r)
+	})
+}
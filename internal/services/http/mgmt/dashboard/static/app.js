@@ -0,0 +1,254 @@
+"use strict";
+
+const refreshInterval = 5000;
+const storageKey = "ctf-reverseproxy-key";
+const api = "/api/v1";
+
+let state = { sessions: {}, instances: [], pool: null, queue: [] };
+
+function key() {
+  return sessionStorage.getItem(storageKey);
+}
+
+async function request(method, path, body) {
+  const options = { method: method, headers: { "X-Management-Key": key() } };
+  if (body !== undefined) {
+    options.headers["Content-Type"] = "application/json";
+    options.body = JSON.stringify(body);
+  }
+
+  const response = await fetch(api + path, options);
+  const envelope = await response.json();
+  //The sessions need the session scope. Any other refused read means that the key is invalid
+  if (response.status === 403 && method === "GET" && path !== "/session") {
+    logout();
+    throw new Error("Invalid management key");
+  }
+
+  if (envelope.Error) {
+    throw new Error(envelope.Error.Message || envelope.Error.Code);
+  }
+  return envelope.Data;
+}
+
+function cell(row, text, className) {
+  const td = document.createElement("td");
+  td.textContent = text;
+  if (className) {
+    td.className = className;
+  }
+  row.appendChild(td);
+  return td;
+}
+
+function button(row, label, action) {
+  const b = document.createElement("button");
+  b.textContent = label;
+  b.addEventListener("click", action);
+  cell(row, "").appendChild(b);
+}
+
+function short(value) {
+  return value.length > 16 ? value.slice(0, 16) + "…" : value;
+}
+
+function duration(seconds) {
+  if (seconds <= 0) {
+    return "0s";
+  }
+  const m = Math.floor(seconds / 60);
+  const s = Math.floor(seconds % 60);
+  return m > 0 ? m + "m " + s + "s" : s + "s";
+}
+
+function countdown(timestamp) {
+  if (!timestamp) {
+    return "";
+  }
+  return duration(timestamp - Date.now() / 1000);
+}
+
+function renderSessions() {
+  const body = document.getElementById("sessions");
+  body.replaceChildren();
+
+  const hashes = Object.keys(state.sessions).sort();
+  for (const hash of hashes) {
+    const session = state.sessions[hash];
+    const row = document.createElement("tr");
+    cell(row, short(hash), "hash").title = hash;
+    cell(row, session.Team || "");
+    cell(row, session.Addr);
+    cell(row, session.Suspended ? "suspended" : "active", session.Suspended ? "warn" : "ok");
+    cell(row, countdown(session.ExpiresOn));
+    cell(row, countdown(session.EndsOn));
+
+    const actions = cell(row, "");
+    for (const [label, action] of [["Recycle", recycle], ["Delete", remove]]) {
+      const b = document.createElement("button");
+      b.textContent = label;
+      b.addEventListener("click", () => action(hash));
+      actions.appendChild(b);
+    }
+    body.appendChild(row);
+  }
+}
+
+// health summarizes the state of the containers of an instance
+function health(instance) {
+  for (const container of instance.Containers) {
+    if (container.State !== "running") {
+      return container.State;
+    }
+    if (container.Health && container.Health !== "healthy") {
+      return container.Health;
+    }
+  }
+  return "healthy";
+}
+
+function renderInstances() {
+  const body = document.getElementById("instances");
+  body.replaceChildren();
+
+  for (const instance of state.instances) {
+    const row = document.createElement("tr");
+    const restarts = instance.Containers.reduce((total, container) => total + container.RestartCount, 0);
+    const uptime = Math.min(...instance.Containers.map((container) => container.Uptime));
+    const status = health(instance);
+
+    cell(row, instance.Addr);
+    cell(row, instance.Status, instance.Status === "removing" || instance.Status === "suspended" ? "warn" : "");
+    cell(row, status, status === "healthy" ? "ok" : "error");
+    cell(row, String(instance.Sessions.length));
+    cell(row, String(restarts));
+    cell(row, duration(uptime));
+    button(row, "Recycle", () => recycleInstance(instance.CtfId));
+    body.appendChild(row);
+  }
+}
+
+function renderPool() {
+  if (!state.pool) {
+    return;
+  }
+  document.getElementById("pool-ready").textContent = state.pool.Ready.length;
+  document.getElementById("pool-size").textContent = state.pool.Size;
+  document.getElementById("pool-queue").textContent = state.pool.Queue;
+
+  const size = document.getElementById("size");
+  if (document.activeElement !== size) {
+    size.value = state.pool.Size;
+  }
+}
+
+function renderQueue() {
+  const body = document.getElementById("queue");
+  body.replaceChildren();
+
+  for (const entry of state.queue) {
+    const row = document.createElement("tr");
+    cell(row, String(entry.Position));
+    cell(row, short(entry.SessionHash), "hash").title = entry.SessionHash;
+    cell(row, String(entry.Priority));
+    cell(row, duration(entry.WaitMs / 1000));
+    body.appendChild(row);
+  }
+}
+
+function render() {
+  renderSessions();
+  renderInstances();
+  renderPool();
+  renderQueue();
+}
+
+function setStatus(message, className) {
+  const status = document.getElementById("status");
+  status.textContent = message;
+  status.className = className || "";
+}
+
+async function refresh() {
+  if (!key()) {
+    return;
+  }
+
+  try {
+    const [sessions, instances, pool, queue] = await Promise.all([
+      request("GET", "/session").catch(() => ({ Sessions: {} })),
+      request("GET", "/instances"),
+      request("GET", "/pool"),
+      request("GET", "/queue"),
+    ]);
+    state.sessions = sessions.Sessions || {};
+    state.instances = instances.Instances || [];
+    state.pool = pool.Pool;
+    state.queue = queue.Queue || [];
+    render();
+    setStatus("Updated " + new Date().toLocaleTimeString());
+  } catch (err) {
+    setStatus(err.message, "error");
+  }
+}
+
+async function action(method, path, body) {
+  try {
+    await request(method, path, body);
+  } catch (err) {
+    setStatus(err.message, "error");
+  }
+  refresh();
+}
+
+function remove(hash) {
+  if (confirm("Delete the session " + hash + "?")) {
+    action("DELETE", "/session/" + encodeURIComponent(hash) + "?hash=true");
+  }
+}
+
+function recycle(hash) {
+  if (confirm("Recycle the instance of the session " + hash + "?")) {
+    action("POST", "/session/" + encodeURIComponent(hash) + "/recycle?hash=true");
+  }
+}
+
+function recycleInstance(ctfId) {
+  if (confirm("Recycle the instance " + ctfId + "?")) {
+    action("DELETE", "/instances/" + ctfId);
+  }
+}
+
+function show() {
+  const connected = key() !== null;
+  document.getElementById("login").hidden = connected;
+  document.getElementById("dashboard").hidden = !connected;
+  document.getElementById("logout").hidden = !connected;
+}
+
+function logout() {
+  sessionStorage.removeItem(storageKey);
+  show();
+}
+
+document.getElementById("login").addEventListener("submit", (e) => {
+  e.preventDefault();
+  sessionStorage.setItem(storageKey, document.getElementById("key").value);
+  document.getElementById("key").value = "";
+  show();
+  refresh();
+});
+
+document.getElementById("resize").addEventListener("submit", (e) => {
+  e.preventDefault();
+  action("PUT", "/pool", { Size: parseInt(document.getElementById("size").value, 10) });
+});
+
+document.getElementById("logout").addEventListener("click", logout);
+
+//The countdowns are updated every second, the data every few seconds
+setInterval(renderSessions, 1000);
+setInterval(refresh, refreshInterval);
+
+show();
+refresh();
@@ -0,0 +1,177 @@
+package events
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/mart123p/ctf-reverseproxy/internal/services/docker"
+	"github.com/mart123p/ctf-reverseproxy/internal/services/sessionmanager"
+	"github.com/mart123p/ctf-reverseproxy/pkg/cbroadcast"
+)
+
+// Events buffered per client. Events are dropped for the clients that do not keep up
+const clientBuffer = 64
+
+// Event streamed to the clients
+type Event struct {
+	Event   string
+	Time    int64
+	Addr    string                       `json:",omitempty"` //Container addr of the docker and session topics
+	Pool    *int                         `json:",omitempty"` //Number of containers ready in the pool
+	Session *sessionmanager.SessionEvent `json:",omitempty"` //Session lifecycle events
+}
+
+// Filter selects the events sent to a client. Empty fields match every event
+type Filter struct {
+	Types       map[string]bool
+	SessionHash string
+}
+
+func (f *Filter) match(event *Event) bool {
+	if len(f.Types) > 0 && !f.Types[event.Event] {
+		return false
+	}
+	if f.SessionHash != "" && (event.Session == nil || event.Session.SessionHash != f.SessionHash) {
+		return false
+	}
+	return true
+}
+
+// Client receives the events matching its filter
+type Client struct {
+	Events  chan Event
+	Done    chan bool //Closed when the hub stops
+	filter  Filter
+	dropped int
+}
+
+// Hub subscribes once to the topics and fans out the events to the clients
+type Hub struct {
+	mu      sync.Mutex
+	clients map[*Client]bool
+
+	shutdown chan bool
+
+	sessionRequest cbroadcast.Channel
+	sessionStop    cbroadcast.Channel
+	sessionEvent   cbroadcast.Channel
+	sessionPool    cbroadcast.Channel
+	dockerReady    cbroadcast.Channel
+	dockerStop     cbroadcast.Channel
+}
+
+var singleton *Hub
+
+// NewHub creates the hub and subscribes to the topics. Must be called in Init
+func NewHub() *Hub {
+	h := &Hub{
+		clients:  make(map[*Client]bool),
+		shutdown: make(chan bool),
+	}
+	h.subscribe()
+
+	singleton = h
+	return h
+}
+
+// Run dispatches the events until Close is called
+func (h *Hub) Run() {
+	for {
+		select {
+		case <-h.shutdown:
+			return
+
+		case <-h.sessionRequest:
+			h.publish(Event{Event: sessionmanager.BSessionRequest})
+
+		case addr := <-h.sessionStop:
+			h.publish(Event{Event: sessionmanager.BSessionStop, Addr: addr.(string)})
+
+		case addr := <-h.dockerReady:
+			h.publish(Event{Event: docker.BDockerReady, Addr: addr.(string)})
+
+		case addr := <-h.dockerStop:
+			h.publish(Event{Event: docker.BDockerStop, Addr: addr.(string)})
+
+		case pool := <-h.sessionPool:
+			size := pool.(int)
+			h.publish(Event{Event: sessionmanager.BSessionPool, Pool: &size})
+
+		case eventObj := <-h.sessionEvent:
+			event := eventObj.(sessionmanager.SessionEvent)
+			event.SessionID = ""
+			h.publish(Event{Event: "session:" + event.Type, Time: event.Time, Addr: event.Addr, Session: &event})
+		}
+	}
+}
+
+// Close stops the hub and the streams of the clients
+func (h *Hub) Close() {
+	close(h.shutdown)
+}
+
+// publish sends the event to the clients. Never blocks
+func (h *Hub) publish(event Event) {
+	if event.Time == 0 {
+		event.Time = time.Now().Unix()
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for client := range h.clients {
+		if !client.filter.match(&event) {
+			continue
+		}
+
+		select {
+		case client.Events <- event:
+		default:
+			client.dropped++
+		}
+	}
+}
+
+// Subscribe adds a client receiving the events matching the filter
+func Subscribe(filter Filter) *Client {
+	client := &Client{
+		Events: make(chan Event, clientBuffer),
+		Done:   singleton.shutdown,
+		filter: filter,
+	}
+
+	singleton.mu.Lock()
+	singleton.clients[client] = true
+	clients := len(singleton.clients)
+	singleton.mu.Unlock()
+
+	log.Printf("[MgmtServer] [Events] -> Client connected | Clients: %d", clients)
+	return client
+}
+
+// Unsubscribe removes the client
+func Unsubscribe(client *Client) {
+	singleton.mu.Lock()
+	delete(singleton.clients, client)
+	singleton.mu.Unlock()
+}
+
+// Dropped returns the number of events dropped for the client since the last call
+func Dropped(client *Client) int {
+	singleton.mu.Lock()
+	defer singleton.mu.Unlock()
+
+	dropped := client.dropped
+	client.dropped = 0
+	return dropped
+}
+
+func (h *Hub) subscribe() {
+	h.sessionRequest, _ = cbroadcast.Subscribe(sessionmanager.BSessionRequest)
+	h.sessionStop, _ = cbroadcast.Subscribe(sessionmanager.BSessionStop)
+	h.sessionEvent, _ = cbroadcast.Subscribe(sessionmanager.BSessionEvent)
+	h.sessionPool, _ = cbroadcast.Subscribe(sessionmanager.BSessionPool)
+	h.dockerReady, _ = cbroadcast.Subscribe(docker.BDockerReady)
+	h.dockerStop, _ = cbroadcast.Subscribe(docker.BDockerStop)
+}
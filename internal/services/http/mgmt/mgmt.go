@@ -9,35 +9,58 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/mart123p/ctf-reverseproxy/internal/config"
 	service "github.com/mart123p/ctf-reverseproxy/internal/services"
+	"github.com/mart123p/ctf-reverseproxy/internal/services/http/mgmt/audit"
+	"github.com/mart123p/ctf-reverseproxy/internal/services/http/mgmt/events"
 	"github.com/mart123p/ctf-reverseproxy/internal/services/http/mgmt/middleware"
 )
 
 type MgmtServer struct {
 	Router *mux.Router
+	API    *mux.Router //Versioned api. The routes are also served on Router as deprecated aliases
 	h      *http.Server
+	events *events.Hub
+	audit  *audit.Logger
 }
 
 func (m *MgmtServer) Init() {
+	m.events = events.NewHub()
+	m.audit = audit.Open()
 }
 
 func (m *MgmtServer) Start() {
 	log.Printf("[MgmtServer] -> Starting Management Server")
 
+	m.setRouter()
+
+	go m.events.Run()
+	go m.run()
+}
+
+// setRouter builds the router with its middlewares and checks the routes against the OpenAPI document
+func (m *MgmtServer) setRouter() {
 	m.Router = mux.NewRouter()
 	m.Router.Use(middleware.LogMiddleware)
-	m.Router.Use(middleware.AuthMiddleware)
+	m.Router.Use(middleware.EnvelopeMiddleware)
+	m.Router.Use(audit.Middleware)
+	m.Router.Use(middleware.AuthMiddleware())
 	m.Router.StrictSlash(true)
+	m.API = m.Router.PathPrefix(middleware.APIPrefix).Subrouter()
 	m.setRoutes()
-	m.Router.NotFoundHandler = middleware.LogMiddleware(http.HandlerFunc(defaultRoute))
-
-	go m.run()
+	m.checkRoutes()
+	m.Router.NotFoundHandler = middleware.LogMiddleware(middleware.EnvelopeMiddleware(http.HandlerFunc(defaultRoute)))
+	m.Router.MethodNotAllowedHandler = middleware.LogMiddleware(middleware.EnvelopeMiddleware(http.HandlerFunc(methodNotAllowed)))
 }
 
 func (m *MgmtServer) Shutdown() {
 	log.Printf("[MgmtServer] -> Stopping Management Server")
+
+	//The event streams never end by themselves
+	m.events.Close()
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	m.h.Shutdown(ctx)
+	m.audit.Close()
 }
 
 func (m *MgmtServer) Register() {
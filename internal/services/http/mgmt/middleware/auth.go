@@ -1,8 +1,19 @@
 package middleware
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/gorilla/mux"
 	"github.com/mart123p/ctf-reverseproxy/internal/config"
 	"github.com/mart123p/ctf-reverseproxy/pkg/rbody"
 )
@@ -12,32 +23,116 @@ var authExceptions map[string]bool // List of routes that do not require authent
 func setAuthExceptions() {
 	if authExceptions == nil {
 		authExceptions = map[string]bool{
-			"/healthz": true,
-			"/metrics": true,
+			"/healthz":                  true,
+			"/metrics":                  true,
+			APIPrefix + "/healthz":      true,
+			APIPrefix + "/openapi.json": true,
 		}
 	}
 }
 
-var mgmtKey string //Key used to authenticate to the management interface
+// The dashboard assets are public. The dashboard calls the api with the management key
+const dashboardPrefix = "/dashboard"
 
-func AuthMiddleware(next http.Handler) http.Handler {
+var mgmtKeys []apiKey //Keys used to authenticate to the management interface
+
+type contextKey int
+
+const callerKey contextKey = 0
+
+// caller holds the name of the key used by the request. It is shared with the middlewares wrapping the authentication
+type caller struct {
+	name string
+}
+
+func withCaller(r *http.Request) (*http.Request, *caller) {
+	if c, ok := r.Context().Value(callerKey).(*caller); ok {
+		return r, c
+	}
+	c := &caller{}
+	return r.WithContext(context.WithValue(r.Context(), callerKey, c)), c
+}
+
+// KeyName returns the name of the management key that authenticated the request
+func KeyName(r *http.Request) string {
+	if c, ok := r.Context().Value(callerKey).(*caller); ok {
+		return c.name
+	}
+	return ""
+}
+
+func clientAddr(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// attemptKey identifies the presented key in the lockout without keeping the key itself
+func attemptKey(presented string) string {
+	digest := sha256.Sum256([]byte(presented))
+	return "key:" + hex.EncodeToString(digest[:8])
+}
+
+// AuthMiddleware returns the authentication middleware. The router wraps the handler on every request so the
+// failed attempts are created once and shared by the requests
+func AuthMiddleware() mux.MiddlewareFunc {
 	setAuthExceptions()
 
-	if mgmtKey == "" {
-		//We extract the key from the config file
-		mgmtKey = config.GetString(config.CMgmtKey)
+	if mgmtKeys == nil {
+		//We extract the keys from the config file
+		mgmtKeys = loadKeys()
 	}
+	failures := newLockout(config.GetInt(config.CMgmtAuthFailures), time.Duration(config.GetInt64(config.CMgmtAuthLockout))*time.Second)
 
+	return func(next http.Handler) http.Handler {
+		return authHandler(next, failures)
+	}
+}
+
+func authHandler(next http.Handler, failures *lockout) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if val, ok := authExceptions[r.URL.Path]; ok && val {
+		if val, ok := authExceptions[r.URL.Path]; (ok && val) || strings.HasPrefix(r.URL.Path, dashboardPrefix) {
 			next.ServeHTTP(w, r)
-		} else {
-			key := r.Header.Get("X-Management-Key")
-			if key == mgmtKey {
-				next.ServeHTTP(w, r)
-			} else {
-				rbody.JSONError(w, http.StatusForbidden, "The header X-Management-Key is missing or invalid")
+			return
+		}
+
+		client := clientAddr(r)
+		presented := r.Header.Get("X-Management-Key")
+		attempt := attemptKey(presented)
+		if failures != nil {
+			wait := failures.locked(client)
+			if keyWait := failures.locked(attempt); keyWait > wait {
+				wait = keyWait
 			}
+			if wait > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				rbody.JSONError(w, http.StatusTooManyRequests, "Too many failed attempts. Try again later")
+				return
+			}
+		}
+
+		key := findKey(mgmtKeys, presented)
+		if key == nil {
+			if failures != nil && presented != "" {
+				if failures.fail(client) {
+					log.Printf("Warning: [MgmtServer] -> Client locked out after too many failed attempts | Client: %s", client)
+				}
+				if failures.fail(attempt) {
+					log.Printf("Warning: [MgmtServer] -> Key locked out after too many failed attempts | Client: %s", client)
+				}
+			}
+			rbody.JSONError(w, http.StatusForbidden, "The header X-Management-Key is missing or invalid")
+			return
+		}
+
+		r, c := withCaller(r)
+		c.name = key.Name
+
+		if scope := requiredScope(r); !key.hasScope(scope) {
+			rbody.JSONError(w, http.StatusForbidden, fmt.Sprintf("The key \"%s\" does not have the %s scope", key.Name, scope))
+			return
 		}
+		next.ServeHTTP(w, r)
 	})
 }
@@ -0,0 +1,150 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// APIPrefix is the prefix of the versioned management api
+const APIPrefix = "/api/v1"
+
+// Envelope wraps every response of the versioned api. Only one of the fields is set
+type Envelope struct {
+	Data  interface{}    `json:",omitempty"`
+	Error *EnvelopeError `json:",omitempty"`
+}
+
+type EnvelopeError struct {
+	Code    string //Stable identifier of the error, see errorCodes
+	Message string
+}
+
+// Error codes returned by the versioned api
+var errorCodes = map[int]string{
+	http.StatusBadRequest:          "invalid_request",
+	http.StatusForbidden:           "forbidden",
+	http.StatusNotFound:            "not_found",
+	http.StatusMethodNotAllowed:    "method_not_allowed",
+	http.StatusConflict:            "conflict",
+	http.StatusTooManyRequests:     "too_many_requests",
+	http.StatusInternalServerError: "internal_error",
+}
+
+func errorCode(status int) string {
+	if code, ok := errorCodes[status]; ok {
+		return code
+	}
+	return "error"
+}
+
+// envelopeWriter buffers the json responses so they can be wrapped. Other responses like the event stream are written as is
+type envelopeWriter struct {
+	http.ResponseWriter
+	status      int
+	passthrough bool
+	body        bytes.Buffer
+}
+
+func (w *envelopeWriter) WriteHeader(status int) {
+	w.status = status
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		w.passthrough = true
+		w.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (w *envelopeWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.passthrough {
+		return w.ResponseWriter.Write(data)
+	}
+	return w.body.Write(data)
+}
+
+func (w *envelopeWriter) Flush() {
+	if !w.passthrough {
+		return
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack gives the connection to the handler. Nothing is wrapped afterwards
+func (w *envelopeWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("the connection cannot be hijacked")
+	}
+	w.passthrough = true
+	return hijacker.Hijack()
+}
+
+// wrap converts the body written by the handler to an envelope. The json of the handler is kept as is
+func (w *envelopeWriter) wrap() Envelope {
+	body := bytes.TrimSpace(w.body.Bytes())
+
+	//The handlers answer some requests with a sentence
+	var message string
+	isMessage := json.Unmarshal(body, &message) == nil
+
+	if w.status >= http.StatusBadRequest {
+		if !isMessage {
+			var fields struct {
+				Error string
+			}
+			_ = json.Unmarshal(body, &fields)
+			message = fields.Error
+		}
+		return Envelope{Error: &EnvelopeError{Code: errorCode(w.status), Message: message}}
+	}
+
+	if isMessage {
+		return Envelope{Data: map[string]string{"Message": message}}
+	}
+	if !json.Valid(body) {
+		return Envelope{}
+	}
+	return Envelope{Data: json.RawMessage(body)}
+}
+
+// EnvelopeMiddleware wraps the json responses of the versioned api
+func EnvelopeMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, APIPrefix+"/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ew := envelopeWriter{ResponseWriter: w}
+		next.ServeHTTP(&ew, r)
+		if ew.passthrough {
+			return
+		}
+		if ew.status == 0 {
+			ew.status = http.StatusOK
+		}
+
+		response, _ := json.Marshal(ew.wrap())
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Del("Content-Length")
+		w.WriteHeader(ew.status)
+		w.Write(response)
+	})
+}
+
+// Deprecated marks the routes outside of the versioned api. The successor is the same route under the api prefix
+func Deprecated(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "<"+APIPrefix+r.URL.Path+">; rel=\"successor-version\"")
+		next(w, r)
+	}
+}
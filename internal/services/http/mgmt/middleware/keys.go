@@ -0,0 +1,155 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/mart123p/ctf-reverseproxy/internal/config"
+)
+
+// Scopes of the management keys. Every key can read the state, the other scopes allow changes and the sensitive reads
+const (
+	ScopeRead    = "read"    //Read only without the session ids and the captured data
+	ScopeSession = "session" //Sessions, teams, reservations and priorities
+	ScopePool    = "pool"    //Pool and instances
+	ScopeFull    = "full"    //Everything including the session hash keys except the web terminal
+	ScopeExec    = "exec"    //Web terminal in the instances. Never implied by another scope
+)
+
+var validScopes = map[string]bool{
+	ScopeRead:    true,
+	ScopeSession: true,
+	ScopePool:    true,
+	ScopeFull:    true,
+	ScopeExec:    true,
+}
+
+// Scope required by the routes whatever the method. The routes are relative to the api prefix.
+// The routes exposing the session ids or the traffic of the players are not readable with the read scope
+var routeScopes = map[string]string{
+	"/session/{id}/exec": ScopeExec,
+
+	"/session":      ScopeSession,
+	"/teams":        ScopeSession,
+	"/reservations": ScopeSession,
+	"/priorities":   ScopeSession,
+	"/events":       ScopeSession,
+
+	"/session/{id}/logs":             ScopeFull,
+	"/captures":                      ScopeFull,
+	"/captures/{sessionHash}/{file}": ScopeFull,
+	"/snapshots":                     ScopeFull,
+	"/snapshots/{sessionHash}/{snapshot}/{file}": ScopeFull,
+	"/audit": ScopeFull,
+}
+
+// Scope required to change the resources under the first segment of the path. Other resources require the full scope
+var writeScopes = map[string]string{
+	"session":      ScopeSession,
+	"teams":        ScopeSession,
+	"reservations": ScopeSession,
+	"priorities":   ScopeSession,
+	"pool":         ScopePool,
+	"instances":    ScopePool,
+}
+
+// apiKey is a named management key. Only the digest of the key is kept in memory
+type apiKey struct {
+	Name   string
+	Hash   string //sha256 hex digest of the key
+	Scopes []string
+
+	digest []byte
+}
+
+func (k *apiKey) hasScope(scope string) bool {
+	if scope == ScopeRead {
+		return true
+	}
+	for _, s := range k.Scopes {
+		if s == scope || (s == ScopeFull && scope != ScopeExec) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadKeys reads the named keys and the legacy key of the config
+func loadKeys() []apiKey {
+	var keys []apiKey
+	if err := config.UnmarshalKey(config.CMgmtKeys, &keys); err != nil {
+		log.Fatalf("[MgmtServer] -> Could not parse the management keys, %s", err)
+	}
+
+	names := make(map[string]bool)
+	for i := range keys {
+		key := &keys[i]
+		if key.Name == "" || names[key.Name] {
+			log.Fatalf("[MgmtServer] -> The management keys must have a unique name")
+		}
+		names[key.Name] = true
+
+		digest, err := hex.DecodeString(strings.TrimPrefix(key.Hash, "sha256:"))
+		if err != nil || len(digest) != sha256.Size {
+			log.Fatalf("[MgmtServer] -> The hash of the management key \"%s\" is not a sha256 hex digest", key.Name)
+		}
+		key.digest = digest
+
+		if len(key.Scopes) == 0 {
+			log.Fatalf("[MgmtServer] -> The management key \"%s\" has no scopes", key.Name)
+		}
+		for _, scope := range key.Scopes {
+			if !validScopes[scope] {
+				log.Fatalf("[MgmtServer] -> Unknown scope \"%s\" for the management key \"%s\"", scope, key.Name)
+			}
+		}
+	}
+
+	if legacy := config.GetString(config.CMgmtKey); legacy != "" {
+		digest := sha256.Sum256([]byte(legacy))
+		keys = append(keys, apiKey{Name: "default", Scopes: []string{ScopeFull}, digest: digest[:]})
+	}
+
+	log.Printf("[MgmtServer] -> %d management keys loaded", len(keys))
+	return keys
+}
+
+// findKey returns the key matching the presented value. Every key is compared so the time does not depend on the match
+func findKey(keys []apiKey, presented string) *apiKey {
+	digest := sha256.Sum256([]byte(presented))
+
+	var found *apiKey
+	for i := range keys {
+		if subtle.ConstantTimeCompare(digest[:], keys[i].digest) == 1 {
+			found = &keys[i]
+		}
+	}
+	return found
+}
+
+// requiredScope returns the scope needed by the request
+func requiredScope(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if template, err := route.GetPathTemplate(); err == nil {
+			if scope, ok := routeScopes[strings.TrimPrefix(template, APIPrefix)]; ok {
+				return scope
+			}
+		}
+	}
+
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return ScopeRead
+	}
+
+	path := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, APIPrefix), "/")
+	segment := strings.SplitN(path, "/", 2)[0]
+	if scope, ok := writeScopes[segment]; ok {
+		return scope
+	}
+	return ScopeFull
+}
@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"sync"
+	"time"
+)
+
+// lockout tracks the failed attempts by client and by presented key. Each one is locked out after too many failures.
+// A successful request does not clear the failures, they expire with the lockout duration
+type lockout struct {
+	mu       sync.Mutex
+	max      int
+	duration time.Duration
+	clients  map[string]*failures //By client address or presented key
+}
+
+type failures struct {
+	count       int
+	last        time.Time
+	lockedUntil time.Time
+}
+
+// newLockout returns nil when the lockout is disabled
+func newLockout(max int, duration time.Duration) *lockout {
+	if max <= 0 {
+		return nil
+	}
+	return &lockout{
+		max:      max,
+		duration: duration,
+		clients:  make(map[string]*failures),
+	}
+}
+
+// locked returns how long the client is still locked out
+func (l *lockout) locked(client string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if f, ok := l.clients[client]; ok {
+		return time.Until(f.lockedUntil)
+	}
+	return 0
+}
+
+// fail records a failed attempt. Returns true if the client is now locked out
+func (l *lockout) fail(client string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.sweep(now)
+
+	f, ok := l.clients[client]
+	if !ok {
+		f = &failures{}
+		l.clients[client] = f
+	}
+	f.count++
+	f.last = now
+
+	if f.count >= l.max {
+		f.count = 0
+		f.lockedUntil = now.Add(l.duration)
+		return true
+	}
+	return false
+}
+
+// sweep forgets the clients without recent failures. The lock must be held
+func (l *lockout) sweep(now time.Time) {
+	for client, f := range l.clients {
+		if now.Sub(f.last) > l.duration && now.After(f.lockedUntil) {
+			delete(l.clients, client)
+		}
+	}
+}
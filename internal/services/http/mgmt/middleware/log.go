@@ -1,7 +1,10 @@
 package middleware
 
 import (
+	"bufio"
+	"errors"
 	"log"
+	"net"
 	"net/http"
 )
 
@@ -15,10 +18,32 @@ func (w *statusWriter) WriteHeader(status int) {
 	w.ResponseWriter.WriteHeader(status)
 }
 
+// Flush is required by the event stream
+func (w *statusWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack is required by the web terminal
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("the connection cannot be hijacked")
+	}
+	w.status = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
+
 func LogMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		sw := statusWriter{ResponseWriter: w}
+		r, c := withCaller(r)
 		next.ServeHTTP(&sw, r)
+		if c.name != "" {
+			log.Printf("[HTTP Mgmt] %s - %s %s %d | Key: %s", r.RemoteAddr, r.Method, r.RequestURI, sw.status, c.name)
+			return
+		}
 		log.Printf("[HTTP Mgmt] %s - %s %s %d", r.RemoteAddr, r.Method, r.RequestURI, sw.status)
 	})
 }
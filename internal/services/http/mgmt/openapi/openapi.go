@@ -0,0 +1,89 @@
+package openapi
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+//go:embed openapi.json
+var spec []byte
+
+// ContentType of the OpenAPI document. It is not wrapped in an envelope
+const ContentType = "application/vnd.oai.openapi+json"
+
+func Handler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(spec)
+}
+
+// operations returns the operations of the spec as "METHOD /path"
+func operations() (map[string]bool, error) {
+	var document struct {
+		Paths map[string]map[string]json.RawMessage
+	}
+	if err := json.Unmarshal(spec, &document); err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]bool)
+	for path, item := range document.Paths {
+		for method := range item {
+			if method == "parameters" {
+				continue
+			}
+			result[strings.ToUpper(method)+" "+path] = true
+		}
+	}
+	return result, nil
+}
+
+// Check compares the routes of the api router with the operations of the spec. The routes are relative to prefix
+func Check(router *mux.Router, prefix string) error {
+	documented, err := operations()
+	if err != nil {
+		return fmt.Errorf("invalid OpenAPI document, %s", err)
+	}
+
+	routes := make(map[string]bool)
+	err = router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		for _, method := range methods {
+			routes[method+" "+strings.TrimPrefix(path, prefix)] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var mismatches []string
+	for operation := range routes {
+		if !documented[operation] {
+			mismatches = append(mismatches, "undocumented route "+operation)
+		}
+	}
+	for operation := range documented {
+		if !routes[operation] {
+			mismatches = append(mismatches, "missing route "+operation)
+		}
+	}
+	if len(mismatches) > 0 {
+		sort.Strings(mismatches)
+		return fmt.Errorf("the routes do not match the OpenAPI document: %s", strings.Join(mismatches, ", "))
+	}
+	return nil
+}
@@ -1,47 +1,115 @@
 package mgmt
 
 import (
+	"log"
 	"net/http"
 
 	"github.com/mart123p/ctf-reverseproxy/internal/services/http/mgmt/api"
+	"github.com/mart123p/ctf-reverseproxy/internal/services/http/mgmt/dashboard"
+	"github.com/mart123p/ctf-reverseproxy/internal/services/http/mgmt/middleware"
+	"github.com/mart123p/ctf-reverseproxy/internal/services/http/mgmt/openapi"
 	"github.com/mart123p/ctf-reverseproxy/pkg/rbody"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-// Functions that can be called by the various HTTP requests types
+// Functions that can be called by the various HTTP requests types. The routes are served under the versioned api
+// and without the prefix as deprecated aliases
+
+// handle registers the route in the versioned api and its deprecated alias
+func (m *MgmtServer) handle(method string, path string, f func(w http.ResponseWriter, r *http.Request)) {
+	m.API.HandleFunc(path, f).Methods(method)
+	m.Router.HandleFunc(path, middleware.Deprecated(f)).Methods(method)
+}
 
 // Get handler for method GET
 func (m *MgmtServer) Get(path string, f func(w http.ResponseWriter, r *http.Request)) {
-	m.Router.HandleFunc(path, f).Methods("GET")
+	m.handle("GET", path, f)
 }
 
 // Post handler for method POST
 func (m *MgmtServer) Post(path string, f func(w http.ResponseWriter, r *http.Request)) {
-	m.Router.HandleFunc(path, f).Methods("POST")
+	m.handle("POST", path, f)
 }
 
 // Put handler for method PUT
 func (m *MgmtServer) Put(path string, f func(w http.ResponseWriter, r *http.Request)) {
-	m.Router.HandleFunc(path, f).Methods("PUT")
+	m.handle("PUT", path, f)
+}
+
+// Patch handler for method PATCH
+func (m *MgmtServer) Patch(path string, f func(w http.ResponseWriter, r *http.Request)) {
+	m.handle("PATCH", path, f)
 }
 
 // Delete handler for method DELETE
 func (m *MgmtServer) Delete(path string, f func(w http.ResponseWriter, r *http.Request)) {
-	m.Router.HandleFunc(path, f).Methods("DELETE")
+	m.handle("DELETE", path, f)
 }
 
 // Head handler for method GET
 func (m *MgmtServer) Head(path string, f func(w http.ResponseWriter, r *http.Request)) {
-	m.Router.HandleFunc(path, f).Methods("HEAD")
+	m.handle("HEAD", path, f)
 }
 
 func (m *MgmtServer) setRoutes() {
 	m.Router.Handle("/metrics", promhttp.Handler())
+	m.API.HandleFunc("/openapi.json", openapi.Handler).Methods("GET")
 	m.Get("/healthz", api.GetHealthz)
 
 	m.Get("/session", api.GetSession)
 	m.Post("/session/{id}", api.PostSession)
+	m.Patch("/session/{id}", api.PatchSession)
 	m.Delete("/session/{id}", api.DeleteSession)
+	m.Post("/session/{id}/extend", api.PostSessionExtend)
+	m.Post("/session/{id}/recycle", api.PostSessionRecycle)
+	m.Get("/session/{id}/logs", api.GetSessionLogs)
+	m.Get("/session/{id}/exec", api.GetSessionExec)
+
+	m.Get("/pool", api.GetPool)
+	m.Put("/pool", api.PutPool)
+
+	m.Get("/instances", api.GetInstances)
+	m.Get("/instances/{ctfId}", api.GetInstance)
+	m.Delete("/instances/{ctfId}", api.DeleteInstance)
+
+	m.Get("/teams", api.GetTeams)
+	m.Put("/teams/{team}", api.PutTeam)
+	m.Delete("/teams/{team}", api.DeleteTeam)
+
+	m.Get("/reservations", api.GetReservations)
+	m.Post("/reservations", api.PostReservations)
+	m.Delete("/reservations/{id}", api.DeleteReservation)
+
+	m.Get("/queue", api.GetQueue)
+	m.Get("/priorities", api.GetPriorities)
+	m.Put("/priorities/{id}", api.PutPriority)
+	m.Delete("/priorities/{id}", api.DeletePriority)
+
+	m.Get("/events", api.GetEvents)
+
+	m.Get("/audit", api.GetAudit)
+
+	m.Get("/snapshots", api.GetSnapshots)
+	m.Get("/snapshots/{sessionHash}/{snapshot}/{file}", api.GetSnapshotFile)
+
+	m.Get("/captures", api.GetCaptures)
+	m.Get("/captures/{sessionHash}/{file}", api.GetCaptureFile)
+
+	m.Get("/keys", api.GetKeys)
+	m.Post("/keys/rotate", api.PostKeysRotate)
+
+	m.Router.PathPrefix(dashboard.Prefix).Handler(dashboard.Handler()).Methods("GET")
+}
+
+// checkRoutes stops the server if the versioned api and the OpenAPI document are out of sync
+func (m *MgmtServer) checkRoutes() {
+	if err := openapi.Check(m.API, middleware.APIPrefix); err != nil {
+		log.Fatalf("[MgmtServer] -> %s", err)
+	}
+}
+
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	rbody.JSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
 }
 
 func defaultRoute(w http.ResponseWriter, r *http.Request) {
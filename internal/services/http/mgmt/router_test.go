@@ -0,0 +1,600 @@
+package mgmt
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mart123p/ctf-reverseproxy/internal/config"
+	"github.com/mart123p/ctf-reverseproxy/internal/services/docker"
+	"github.com/mart123p/ctf-reverseproxy/internal/services/http/mgmt/middleware"
+	"github.com/mart123p/ctf-reverseproxy/internal/services/http/mgmt/openapi"
+	"github.com/mart123p/ctf-reverseproxy/internal/services/http/reverseproxy"
+	"github.com/mart123p/ctf-reverseproxy/internal/services/sessionmanager"
+	"github.com/mart123p/ctf-reverseproxy/pkg/cbroadcast"
+	"github.com/spf13/viper"
+)
+
+const (
+	fullKey = "test-full-key"
+	readKey = "test-read-key"
+	execKey = "test-exec-key"
+)
+
+// Session hash of the snapshots and the captures written before the tests
+const fixtureHash = "0123456789abcdef"
+
+// server is the management server of the tests. The session manager runs with a stub of the docker service
+var server *MgmtServer
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+
+	dir, err := os.MkdirTemp("", "mgmt")
+	if err != nil {
+		panic(err)
+	}
+	setupFixtures(dir)
+	setupServices(dir)
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// setupFixtures writes a snapshot and a capture of fixtureHash
+func setupFixtures(dir string) {
+	snapshot := filepath.Join(dir, "snapshots", fixtureHash, "1-1700000000")
+	capture := filepath.Join(dir, "captures", fixtureHash)
+	for _, path := range []string{snapshot, capture} {
+		if err := os.MkdirAll(path, 0750); err != nil {
+			panic(err)
+		}
+	}
+
+	manifest := `{"Id":"1-1700000000","CtfId":1,"Time":1700000000,"Sessions":["` + fixtureHash + `"],"Containers":[{"Name":"challenge-1","Changes":1}]}`
+	files := map[string]string{
+		filepath.Join(snapshot, "snapshot.json"):    manifest,
+		filepath.Join(snapshot, "challenge-1.diff"): "A /tmp/flag\n",
+		filepath.Join(capture, "1700000000.har"):    `{"log":{"version":"1.2","creator":{"name":"ctf-reverseproxy","version":"1.0"},"entries":[` + "\n\n]}}\n",
+	}
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0640); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// setupServices starts the session manager and builds the router shared by the tests
+func setupServices(dir string) {
+	readDigest := sha256.Sum256([]byte(readKey))
+	execDigest := sha256.Sum256([]byte(execKey))
+	viper.Set(config.CMgmtKey, fullKey)
+	viper.Set(config.CMgmtKeys, []map[string]interface{}{
+		{"Name": "reader", "Hash": hex.EncodeToString(readDigest[:]), "Scopes": []string{middleware.ScopeRead}},
+		{"Name": "terminal", "Hash": hex.EncodeToString(execDigest[:]), "Scopes": []string{middleware.ScopeExec}},
+	})
+	viper.Set(config.CMgmtAuditFile, filepath.Join(dir, "audit.log"))
+	viper.Set(config.CMgmtAuditMaxBackups, -1)
+	viper.Set(config.CReverseProxySessionSalt, "test-salt")
+	viper.Set(config.CReverseProxySessionTimeout, 300)
+	viper.Set(config.CReverseProxyPool, 2)
+	viper.Set(config.CReverseProxyCaptureDir, filepath.Join(dir, "captures"))
+	viper.Set(config.CReverseProxyCaptureMaxSize, 10)
+	viper.Set(config.CReverseProxyCaptureMaxFiles, 10)
+	viper.Set(config.CDockerSnapshotDir, filepath.Join(dir, "snapshots"))
+
+	cbroadcast.NonBlockingBuffer(func(name string) {})
+	(&docker.DockerService{}).Register()
+	(&reverseproxy.ReverseProxy{}).Register()
+
+	sessions := &sessionmanager.SessionManagerService{}
+	sessions.Register()
+	sessions.Init()
+
+	//Enables the captures
+	(&reverseproxy.ReverseProxy{}).Init()
+
+	stubDocker()
+	sessions.Start()
+	cbroadcast.Broadcast(docker.BDockerState, []string{})
+
+	server = &MgmtServer{}
+	server.Init()
+	go server.events.Run()
+	server.setRouter()
+}
+
+// stubDocker plays the docker service. The containers requested by the session manager are ready at once and listed
+// in the instances with an instance that the session manager does not use
+func stubDocker() {
+	unmanaged := docker.Instance{CtfId: 100, Addr: "ctf-100:80", Containers: []docker.Container{}}
+	docker.SetInstances([]docker.Instance{unmanaged})
+
+	requests, _ := cbroadcast.Subscribe(sessionmanager.BSessionRequest)
+	go func() {
+		created := []docker.Instance{unmanaged}
+		for range requests {
+			ctfId := len(created)
+			instance := docker.Instance{
+				CtfId: ctfId,
+				Addr:  fmt.Sprintf("ctf-%d:80", ctfId),
+				Containers: []docker.Container{{
+					Name:        fmt.Sprintf("challenge-%d", ctfId),
+					Image:       "challenge:latest",
+					ImageDigest: "sha256:" + strings.Repeat("0", 64),
+					State:       "running",
+					StartedOn:   time.Now().Unix(),
+					Networks:    []string{fmt.Sprintf("ctf-%d", ctfId)},
+				}},
+			}
+			created = append(created, instance)
+			docker.SetInstances(append([]docker.Instance(nil), created...))
+			cbroadcast.Broadcast(docker.BDockerReady, instance.Addr)
+		}
+	}()
+}
+
+// newTestServer builds another router on the running services. The middlewares read the config again
+func newTestServer(t *testing.T) *MgmtServer {
+	t.Helper()
+
+	m := &MgmtServer{}
+	m.setRouter()
+	return m
+}
+
+// serve sends the request through the router. A streamed response ends once its headers are written
+func serve(m *MgmtServer, method string, path string, key string, body string, stream bool) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if key != "" {
+		r.Header.Set("X-Management-Key", key)
+	}
+	if body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	if stream {
+		ctx, cancel := context.WithCancel(r.Context())
+		cancel()
+		r = r.WithContext(ctx)
+	}
+	w := httptest.NewRecorder()
+	m.Router.ServeHTTP(w, r)
+	return w
+}
+
+// document is the OpenAPI document served by the api
+type document map[string]interface{}
+
+func loadDocument(t *testing.T, m *MgmtServer) document {
+	t.Helper()
+
+	w := serve(m, "GET", middleware.APIPrefix+"/openapi.json", "", "", false)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /openapi.json: status %d", w.Code)
+	}
+	if contentType := w.Header().Get("Content-Type"); contentType != openapi.ContentType {
+		t.Fatalf("GET /openapi.json: content type %q", contentType)
+	}
+
+	var d document
+	if err := json.Unmarshal(w.Body.Bytes(), &d); err != nil {
+		t.Fatalf("GET /openapi.json: %s", err)
+	}
+	return d
+}
+
+// resolve follows the $ref of the object. Only the references inside the document are supported
+func (d document) resolve(object map[string]interface{}) map[string]interface{} {
+	for {
+		ref, ok := object["$ref"].(string)
+		if !ok {
+			return object
+		}
+		var node interface{} = map[string]interface{}(d)
+		for _, segment := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+			node = node.(map[string]interface{})[segment]
+		}
+		object = node.(map[string]interface{})
+	}
+}
+
+// responseContent returns the media types documented for the response of the operation with the status
+func (d document) responseContent(method string, template string, status int) (map[string]interface{}, error) {
+	paths := d["paths"].(map[string]interface{})
+	item, ok := paths[template].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s is not documented", template)
+	}
+	operation, ok := item[strings.ToLower(method)].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s %s is not documented", method, template)
+	}
+	response, ok := operation["responses"].(map[string]interface{})[fmt.Sprint(status)].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s %s does not document the status %d", method, template, status)
+	}
+	content, ok := d.resolve(response)["content"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s %s does not document a body for the status %d", method, template, status)
+	}
+	return content, nil
+}
+
+// responseSchema returns the json schema of the response of the operation with the status
+func (d document) responseSchema(method string, template string, status int) (map[string]interface{}, error) {
+	content, err := d.responseContent(method, template, status)
+	if err != nil {
+		return nil, err
+	}
+	media, ok := content["application/json"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s %s does not document a json body for the status %d", method, template, status)
+	}
+	return d.resolve(media["schema"].(map[string]interface{})), nil
+}
+
+// checkResponse checks that the media type of the response is documented. The json bodies are validated against their schema
+func (d document) checkResponse(method string, template string, w *httptest.ResponseRecorder) error {
+	content, err := d.responseContent(method, template, w.Code)
+	if err != nil {
+		return err
+	}
+
+	mediaType := strings.TrimSpace(strings.Split(w.Header().Get("Content-Type"), ";")[0])
+	media, ok := content[mediaType].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("%s %s does not document the content type %q for the status %d", method, template, mediaType, w.Code)
+	}
+	if mediaType != "application/json" {
+		return nil
+	}
+
+	var body interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		return fmt.Errorf("invalid json: %s", err)
+	}
+	return d.validate(d.resolve(media["schema"].(map[string]interface{})), body, "body")
+}
+
+// validate checks the value against the subset of json schema used by the document
+func (d document) validate(schema map[string]interface{}, value interface{}, at string) error {
+	schema = d.resolve(schema)
+
+	if value == nil {
+		if nullable, _ := schema["nullable"].(bool); nullable {
+			return nil
+		}
+		return fmt.Errorf("%s: null is not allowed", at)
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, allowed := range enum {
+			found = found || allowed == value
+		}
+		if !found {
+			return fmt.Errorf("%s: %v is not one of %v", at, value, enum)
+		}
+	}
+
+	switch schema["type"] {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected an object", at)
+		}
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, name := range required {
+				if _, ok := object[name.(string)]; !ok {
+					return fmt.Errorf("%s: missing the field %s", at, name)
+				}
+			}
+		}
+
+		properties, _ := schema["properties"].(map[string]interface{})
+		additional, _ := schema["additionalProperties"].(map[string]interface{})
+		names := make([]string, 0, len(object))
+		for name := range object {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			var err error
+			if property, ok := properties[name].(map[string]interface{}); ok {
+				err = d.validate(property, object[name], at+"."+name)
+			} else if additional != nil {
+				err = d.validate(additional, object[name], at+"."+name)
+			} else if properties != nil {
+				err = fmt.Errorf("%s: the field %s is not documented", at, name)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected an array", at)
+		}
+		items, _ := schema["items"].(map[string]interface{})
+		for i, item := range array {
+			if items == nil {
+				break
+			}
+			if err := d.validate(items, item, fmt.Sprintf("%s[%d]", at, i)); err != nil {
+				return err
+			}
+		}
+	case "string":
+		if _, ok := value.(string); !ok {
+			return fmt.Errorf("%s: expected a string", at)
+		}
+	case "integer":
+		if number, ok := value.(float64); !ok || number != math.Trunc(number) {
+			return fmt.Errorf("%s: expected an integer", at)
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			return fmt.Errorf("%s: expected a number", at)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s: expected a boolean", at)
+		}
+	}
+	return nil
+}
+
+// waitPool waits for the stub to create the containers of the pool
+func waitPool(t *testing.T) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if len(sessionmanager.GetPool().Ready) >= config.GetInt(config.CReverseProxyPool) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("the pool was not filled")
+}
+
+// Every operation of the document is called. The cases run in order on the same sessions and instances
+func TestRoutesMatchDocument(t *testing.T) {
+	d := loadDocument(t, server)
+	waitPool(t)
+
+	//Recycled at the end of the instance cases
+	var ctfId int
+	fmt.Sscanf(sessionmanager.GetPool().Ready[0], "ctf-%d:80", &ctfId)
+	instance := fmt.Sprintf("/instances/%d", ctfId)
+
+	snapshot := "/snapshots/" + fixtureHash + "/1-1700000000"
+	capture := "/captures/" + fixtureHash
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		template string
+		key      string
+		body     string
+		status   int
+		config   map[string]interface{} //Set during the request
+	}{
+		{"health check without a key", "GET", "/healthz", "/healthz", "", "", http.StatusOK, nil},
+		{"document without a key", "GET", "/openapi.json", "/openapi.json", "", "", http.StatusOK, nil},
+		{"missing key", "GET", "/keys", "/keys", "", "", http.StatusForbidden, nil},
+		{"invalid key", "GET", "/keys", "/keys", "invalid", "", http.StatusForbidden, nil},
+		{"keys", "GET", "/keys", "/keys", fullKey, "", http.StatusOK, nil},
+
+		{"pool", "GET", "/pool", "/pool", fullKey, "", http.StatusOK, nil},
+		{"resize the pool", "PUT", "/pool", "/pool", fullKey, `{"Size": 2}`, http.StatusOK, nil},
+		{"resize the pool with an invalid body", "PUT", "/pool", "/pool", fullKey, `{"Size": -1}`, http.StatusBadRequest, nil},
+
+		{"create a session with a lifetime", "POST", "/session/player-1", "/session/{id}", fullKey, `{"MaxLifetime": 3600}`, http.StatusCreated, nil},
+		{"create a session", "POST", "/session/player-2", "/session/{id}", fullKey, "", http.StatusCreated, nil},
+		{"create a session with an invalid body", "POST", "/session/player-3", "/session/{id}", fullKey, "{", http.StatusBadRequest, nil},
+		{"sessions", "GET", "/session", "/session", fullKey, "", http.StatusOK, nil},
+		{"sessions without the session scope", "GET", "/session", "/session", readKey, "", http.StatusForbidden, nil},
+		{"update a session", "PATCH", "/session/player-1", "/session/{id}", fullKey, `{"MaxLifetime": 7200}`, http.StatusOK, nil},
+		{"update an unknown session", "PATCH", "/session/unknown", "/session/{id}", fullKey, `{"MaxLifetime": 7200}`, http.StatusNotFound, nil},
+		{"update a session with an invalid body", "PATCH", "/session/player-1", "/session/{id}", fullKey, "{", http.StatusBadRequest, nil},
+
+		{"extend a session", "POST", "/session/player-1/extend", "/session/{id}/extend", fullKey, `{"Seconds": 60}`, http.StatusOK, nil},
+		{"extend a session without a lifetime", "POST", "/session/player-2/extend", "/session/{id}/extend", fullKey, `{"Seconds": 60}`, http.StatusConflict, nil},
+		{"extend an unknown session", "POST", "/session/unknown/extend", "/session/{id}/extend", fullKey, `{"Seconds": 60}`, http.StatusNotFound, nil},
+		{"extend a session with an invalid body", "POST", "/session/player-1/extend", "/session/{id}/extend", fullKey, `{"Seconds": 0}`, http.StatusBadRequest, nil},
+
+		{"logs with an invalid tail", "GET", "/session/player-1/logs?tail=x", "/session/{id}/logs", fullKey, "", http.StatusBadRequest, nil},
+		{"logs of an unknown session", "GET", "/session/unknown/logs", "/session/{id}/logs", fullKey, "", http.StatusNotFound, nil},
+		{"logs without a docker daemon", "GET", "/session/player-1/logs", "/session/{id}/logs", fullKey, "", http.StatusInternalServerError, nil},
+
+		{"exec in an unknown session", "GET", "/session/unknown/exec", "/session/{id}/exec", execKey, "", http.StatusNotFound, nil},
+		{"exec without a docker daemon", "GET", "/session/player-1/exec", "/session/{id}/exec", execKey, "", http.StatusInternalServerError, nil},
+		{"exec without the exec scope", "GET", "/session/player-1/exec", "/session/{id}/exec", fullKey, "", http.StatusForbidden, nil},
+
+		{"recycle a session", "POST", "/session/player-2/recycle", "/session/{id}/recycle", fullKey, "", http.StatusOK, nil},
+		{"recycle an unknown session", "POST", "/session/unknown/recycle", "/session/{id}/recycle", fullKey, "", http.StatusNotFound, nil},
+
+		{"set a team", "PUT", "/teams/red", "/teams/{team}", fullKey, `{"Members": ["player-1"]}`, http.StatusOK, nil},
+		{"set a team with an invalid body", "PUT", "/teams/red", "/teams/{team}", fullKey, "{", http.StatusBadRequest, nil},
+		{"teams", "GET", "/teams", "/teams", fullKey, "", http.StatusOK, nil},
+		{"teams without the session scope", "GET", "/teams", "/teams", readKey, "", http.StatusForbidden, nil},
+		{"delete a team", "DELETE", "/teams/red", "/teams/{team}", fullKey, "", http.StatusOK, nil},
+		{"delete an unknown team", "DELETE", "/teams/red", "/teams/{team}", fullKey, "", http.StatusNotFound, nil},
+
+		{"reserve sessions", "POST", "/reservations", "/reservations", fullKey, `{"Sessions": ["player-4"]}`, http.StatusCreated, nil},
+		{"reservations with an invalid body", "POST", "/reservations", "/reservations", fullKey, "{", http.StatusBadRequest, nil},
+		{"reservations without the session scope", "POST", "/reservations", "/reservations", readKey, `{"Sessions": ["a"]}`, http.StatusForbidden, nil},
+		{"reservations of shared instances", "POST", "/reservations", "/reservations", fullKey, `{"Sessions": ["player-5"]}`, http.StatusConflict, map[string]interface{}{config.CReverseProxySharedEnabled: true}},
+		{"reservations", "GET", "/reservations", "/reservations", fullKey, "", http.StatusOK, nil},
+		{"release a reservation", "DELETE", "/reservations/player-4", "/reservations/{id}", fullKey, "", http.StatusOK, nil},
+		{"release an unknown reservation", "DELETE", "/reservations/player-4", "/reservations/{id}", fullKey, "", http.StatusNotFound, nil},
+
+		{"set a priority", "PUT", "/priorities/player-1", "/priorities/{id}", fullKey, `{"Priority": 5}`, http.StatusOK, nil},
+		{"set a priority with an invalid body", "PUT", "/priorities/player-1", "/priorities/{id}", fullKey, "{", http.StatusBadRequest, nil},
+		{"priorities", "GET", "/priorities", "/priorities", fullKey, "", http.StatusOK, nil},
+		{"priorities without the session scope", "GET", "/priorities", "/priorities", readKey, "", http.StatusForbidden, nil},
+		{"delete a priority", "DELETE", "/priorities/player-1", "/priorities/{id}", fullKey, "", http.StatusOK, nil},
+		{"delete an unknown priority", "DELETE", "/priorities/player-1", "/priorities/{id}", fullKey, "", http.StatusNotFound, nil},
+
+		{"queue", "GET", "/queue", "/queue", fullKey, "", http.StatusOK, nil},
+		{"events", "GET", "/events", "/events", fullKey, "", http.StatusOK, nil},
+
+		{"audit", "GET", "/audit", "/audit", fullKey, "", http.StatusOK, nil},
+		{"audit with an invalid filter", "GET", "/audit?from=x", "/audit", fullKey, "", http.StatusBadRequest, nil},
+
+		{"instances", "GET", "/instances", "/instances", fullKey, "", http.StatusOK, nil},
+		{"instance", "GET", "/instances/1", "/instances/{ctfId}", fullKey, "", http.StatusOK, nil},
+		{"instance with an invalid id", "GET", "/instances/x", "/instances/{ctfId}", fullKey, "", http.StatusBadRequest, nil},
+		{"unknown instance", "GET", "/instances/999", "/instances/{ctfId}", fullKey, "", http.StatusNotFound, nil},
+		{"recycle an unmanaged instance", "DELETE", "/instances/100", "/instances/{ctfId}", fullKey, "", http.StatusConflict, nil},
+		{"recycle an unknown instance", "DELETE", "/instances/999", "/instances/{ctfId}", fullKey, "", http.StatusNotFound, nil},
+		{"recycle an instance with an invalid id", "DELETE", "/instances/x", "/instances/{ctfId}", fullKey, "", http.StatusBadRequest, nil},
+		{"recycle an instance", "DELETE", instance, "/instances/{ctfId}", fullKey, "", http.StatusOK, nil},
+
+		{"snapshots", "GET", "/snapshots", "/snapshots", fullKey, "", http.StatusOK, nil},
+		{"snapshots without a directory", "GET", "/snapshots", "/snapshots", fullKey, "", http.StatusConflict, map[string]interface{}{config.CDockerSnapshotDir: ""}},
+		{"snapshot file", "GET", snapshot + "/challenge-1.diff", "/snapshots/{sessionHash}/{snapshot}/{file}", fullKey, "", http.StatusOK, nil},
+		{"missing snapshot file", "GET", snapshot + "/missing.diff", "/snapshots/{sessionHash}/{snapshot}/{file}", fullKey, "", http.StatusNotFound, nil},
+		{"snapshot file without a directory", "GET", snapshot + "/challenge-1.diff", "/snapshots/{sessionHash}/{snapshot}/{file}", fullKey, "", http.StatusConflict, map[string]interface{}{config.CDockerSnapshotDir: ""}},
+
+		{"captures", "GET", "/captures", "/captures", fullKey, "", http.StatusOK, nil},
+		{"capture file", "GET", capture + "/1700000000.har", "/captures/{sessionHash}/{file}", fullKey, "", http.StatusOK, nil},
+		{"missing capture file", "GET", capture + "/missing.har", "/captures/{sessionHash}/{file}", fullKey, "", http.StatusNotFound, nil},
+
+		{"delete a session", "DELETE", "/session/player-1", "/session/{id}", fullKey, "", http.StatusOK, nil},
+		{"delete an unknown session", "DELETE", "/session/player-1", "/session/{id}", fullKey, "", http.StatusNotFound, nil},
+
+		{"rotate the keys with an invalid body", "POST", "/keys/rotate", "/keys/rotate", fullKey, "[", http.StatusBadRequest, nil},
+		{"rotate the keys", "POST", "/keys/rotate", "/keys/rotate", fullKey, `{"Key": "test-rotated-key"}`, http.StatusOK, nil},
+	}
+
+	called := make(map[string]bool)
+	for _, test := range tests {
+		called[test.method+" "+test.template] = true
+
+		t.Run(test.name, func(t *testing.T) {
+			for key, value := range test.config {
+				previous := viper.Get(key)
+				viper.Set(key, value)
+				defer viper.Set(key, previous)
+			}
+
+			//The event stream ends once its headers are written
+			stream := test.template == "/events"
+
+			done := make(chan *httptest.ResponseRecorder, 1)
+			go func() {
+				done <- serve(server, test.method, middleware.APIPrefix+test.path, test.key, test.body, stream)
+			}()
+
+			var w *httptest.ResponseRecorder
+			select {
+			case w = <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("no response")
+			}
+
+			if w.Code != test.status {
+				t.Fatalf("status %d, expected %d: %s", w.Code, test.status, w.Body.String())
+			}
+			if w.Header().Get("Deprecation") != "" {
+				t.Fatalf("the versioned route is marked as deprecated")
+			}
+			if err := d.checkResponse(test.method, test.template, w); err != nil {
+				t.Fatalf("%s: %s", err, w.Body.String())
+			}
+		})
+	}
+
+	for template, item := range d["paths"].(map[string]interface{}) {
+		for method := range item.(map[string]interface{}) {
+			if method == "parameters" {
+				continue
+			}
+			if operation := strings.ToUpper(method) + " " + template; !called[operation] {
+				t.Errorf("%s has no test case", operation)
+			}
+		}
+	}
+}
+
+// A valid key does not clear the failed attempts of the client
+func TestLockout(t *testing.T) {
+	viper.Set(config.CMgmtAuthFailures, 3)
+	viper.Set(config.CMgmtAuthLockout, 60)
+	t.Cleanup(func() {
+		viper.Set(config.CMgmtAuthFailures, 0)
+	})
+	m := newTestServer(t)
+
+	for _, key := range []string{"guess-1", "guess-2", readKey, "guess-3"} {
+		serve(m, "GET", middleware.APIPrefix+"/keys", key, "", false)
+	}
+
+	w := serve(m, "GET", middleware.APIPrefix+"/keys", fullKey, "", false)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("status %d, expected the client to be locked out", w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Fatalf("missing the Retry-After header")
+	}
+}
+
+// The deprecated aliases return the payload of the Data field without the envelope
+func TestDeprecatedAlias(t *testing.T) {
+	m := newTestServer(t)
+	d := loadDocument(t, m)
+
+	w := serve(m, "GET", "/keys", fullKey, "", false)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+	if w.Header().Get("Deprecation") != "true" {
+		t.Fatalf("the alias is not marked as deprecated")
+	}
+	if link := w.Header().Get("Link"); link != "<"+middleware.APIPrefix+"/keys>; rel=\"successor-version\"" {
+		t.Fatalf("link %q", link)
+	}
+
+	schema, err := d.responseSchema("GET", "/keys", http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := schema["properties"].(map[string]interface{})["Data"].(map[string]interface{})
+
+	var body interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid json: %s", err)
+	}
+	if err := d.validate(data, body, "body"); err != nil {
+		t.Fatalf("%s: %s", err, w.Body.String())
+	}
+
+	w = serve(m, "GET", "/keys", "", "", false)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("status %d without a key", w.Code)
+	}
+	var failure struct {
+		Error string
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &failure); err != nil || failure.Error == "" {
+		t.Fatalf("the alias did not return the raw error: %s", w.Body.String())
+	}
+}
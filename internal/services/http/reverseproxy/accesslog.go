@@ -0,0 +1,164 @@
+package reverseproxy
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mart123p/ctf-reverseproxy/internal/config"
+	"github.com/mart123p/ctf-reverseproxy/pkg/rotate"
+)
+
+// Header used to correlate a request between the player, the access log and the challenge
+const requestIdHeader = "X-Request-Id"
+
+type accessEntry struct {
+	Time       string  `json:"time"`
+	RequestId  string  `json:"request_id"`
+	ClientIP   string  `json:"client_ip"`
+	Session    string  `json:"session"`
+	Instance   string  `json:"instance"`
+	Method     string  `json:"method"`
+	Host       string  `json:"host"`
+	Path       string  `json:"path"`
+	Status     int     `json:"status"`
+	BytesIn    int64   `json:"bytes_in"`
+	BytesOut   int64   `json:"bytes_out"`
+	UpstreamMs float64 `json:"upstream_ms"`
+	QueueMs    float64 `json:"queue_ms"`
+	Error      string  `json:"error,omitempty"`
+}
+
+type accessLogger struct {
+	out *rotate.Writer
+
+	stdout sync.Mutex //Keeps the lines written to the standard output whole
+}
+
+// newAccessLogger opens the access log file. When no file is configured the entries are written to the standard output
+func newAccessLogger() *accessLogger {
+	a := &accessLogger{}
+
+	path := config.GetString(config.CReverseProxyAccessLogFile)
+	if path == "" {
+		return a
+	}
+
+	maxSize := config.GetInt64(config.CReverseProxyAccessLogMaxSize) * 1024 * 1024
+	out, err := rotate.Open(path, maxSize, config.GetInt(config.CReverseProxyAccessLogMaxBackups))
+	if err != nil {
+		log.Fatalf("[ReverseProxy] -> Could not open the access log \"%s\", %s", path, err)
+	}
+	a.out = out
+	log.Printf("[ReverseProxy] -> Access log written to \"%s\"", path)
+	return a
+}
+
+func (a *accessLogger) write(entry *accessEntry) {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("Warning: [ReverseProxy] -> Could not marshal access log entry, %s", err)
+		return
+	}
+	line = append(line, '\n')
+
+	if a.out == nil {
+		a.stdout.Lock()
+		os.Stdout.Write(line)
+		a.stdout.Unlock()
+		return
+	}
+
+	if _, err := a.out.Write(line); err != nil {
+		log.Printf("Warning: [ReverseProxy] -> Could not write access log entry, %s", err)
+	}
+}
+
+func (a *accessLogger) close() {
+	if a.out != nil {
+		a.out.Close()
+	}
+}
+
+// newRequestId returns a random identifier for a proxied request
+func newRequestId() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func getClientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+func toMilliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000.0
+}
+
+// countingReader counts the bytes of the request body read by the reverse proxy
+type countingReader struct {
+	io.ReadCloser
+	bytes int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	atomic.AddInt64(&c.bytes, int64(n))
+	return n, err
+}
+
+// accessWriter records the status and the size of the response sent to the player
+type accessWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *accessWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *accessWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack is required for protocol upgrades such as websockets
+func (w *accessWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hijacker, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return hijacker.Hijack()
+	}
+	return nil, nil, errors.New("hijack is not supported")
+}
+
+func (w *accessWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
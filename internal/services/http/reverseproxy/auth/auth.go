@@ -0,0 +1,45 @@
+package auth
+
+import (
+	"errors"
+	"log"
+
+	"github.com/mart123p/ctf-reverseproxy/internal/config"
+)
+
+var ErrMissingToken = errors.New("the session token is missing")
+var ErrInvalidToken = errors.New("the session token is invalid")
+var ErrUnavailable = errors.New("the session token could not be validated")
+
+// Identity of a player once the session token is validated
+type Identity struct {
+	ID   string //Identifier used as the session id
+	Team string //Team of the player when it is known from the token
+
+	Priority int //Priority in the queue when no instance is available. Higher is served first
+}
+
+// Authenticator validates the session token sent by the player
+type Authenticator interface {
+	// Authenticate returns the identity bound to the token
+	Authenticate(token string) (Identity, error)
+}
+
+// New returns the authenticator set in the config file. Returns nil when the authentication is disabled
+func New() Authenticator {
+	authType := config.GetString(config.CReverseProxyAuthType)
+
+	switch authType {
+	case "":
+		return nil
+	case "jwt":
+		log.Printf("[ReverseProxy] [Auth] -> Session tokens are validated as JWT")
+		return newJWTAuthenticator()
+	case "http":
+		log.Printf("[ReverseProxy] [Auth] -> Session tokens are validated by %s", config.GetString(config.CReverseProxyAuthHTTPUrl))
+		return newHTTPAuthenticator()
+	}
+
+	log.Fatalf("[ReverseProxy] [Auth] -> Unknown authenticator \"%s\". Use \"jwt\" or \"http\"", authType)
+	return nil
+}
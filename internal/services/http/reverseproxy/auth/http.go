@@ -0,0 +1,160 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mart123p/ctf-reverseproxy/internal/config"
+)
+
+// Rejected tokens are cached for a shorter time so that a fixed token is accepted quickly
+const negativeCacheTTL = 10 * time.Second
+
+// Response of a CTFd compatible token check endpoint such as /api/v1/users/me
+type ctfdResponse struct {
+	Success bool                   `json:"success"`
+	Data    map[string]interface{} `json:"data"`
+}
+
+type cacheEntry struct {
+	identity Identity
+	err      error
+	expires  time.Time
+}
+
+type httpAuthenticator struct {
+	url    string
+	header string
+	scheme string
+	field  string
+	ttl    time.Duration
+	client *http.Client
+
+	priorityField string
+
+	mu        sync.Mutex
+	cache     map[[sha256.Size]byte]cacheEntry
+	lastSweep time.Time
+}
+
+func newHTTPAuthenticator() *httpAuthenticator {
+	return &httpAuthenticator{
+		url:    config.GetString(config.CReverseProxyAuthHTTPUrl),
+		header: config.GetString(config.CReverseProxyAuthHTTPHeader),
+		scheme: config.GetString(config.CReverseProxyAuthHTTPScheme),
+		field:  config.GetString(config.CReverseProxyAuthHTTPField),
+		ttl:    time.Duration(config.GetInt64(config.CReverseProxyAuthHTTPCache)) * time.Second,
+		client: &http.Client{
+			Timeout: time.Duration(config.GetInt64(config.CReverseProxyAuthHTTPTimeout)) * time.Second,
+		},
+		cache:     make(map[[sha256.Size]byte]cacheEntry),
+		lastSweep: time.Now(),
+
+		priorityField: config.GetString(config.CReverseProxyAuthHTTPPriorityField),
+	}
+}
+
+func (a *httpAuthenticator) Authenticate(token string) (Identity, error) {
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return Identity{}, ErrMissingToken
+	}
+
+	key := sha256.Sum256([]byte(token))
+	if entry, ok := a.cached(key); ok {
+		return entry.identity, entry.err
+	}
+
+	identity, err := a.check(token)
+	if errors.Is(err, ErrUnavailable) {
+		//Never cache an error of the scoreboard
+		return identity, err
+	}
+
+	ttl := a.ttl
+	if err != nil {
+		ttl = negativeCacheTTL
+	}
+
+	a.mu.Lock()
+	a.cache[key] = cacheEntry{identity: identity, err: err, expires: time.Now().Add(ttl)}
+	a.mu.Unlock()
+
+	return identity, err
+}
+
+func (a *httpAuthenticator) cached(key [sha256.Size]byte) (cacheEntry, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(a.lastSweep) > time.Minute {
+		for k, entry := range a.cache {
+			if entry.expires.Before(now) {
+				delete(a.cache, k)
+			}
+		}
+		a.lastSweep = now
+	}
+
+	entry, ok := a.cache[key]
+	if !ok || entry.expires.Before(now) {
+		return cacheEntry{}, false
+	}
+	return entry, true
+}
+
+// check calls the scoreboard to validate the token
+func (a *httpAuthenticator) check(token string) (Identity, error) {
+	req, err := http.NewRequest(http.MethodGet, a.url, nil)
+	if err != nil {
+		return Identity{}, ErrUnavailable
+	}
+
+	value := token
+	if a.scheme != "" {
+		value = a.scheme + " " + token
+	}
+	req.Header.Set(a.header, value)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return Identity{}, ErrUnavailable
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return Identity{}, ErrInvalidToken
+	case resp.StatusCode != http.StatusOK:
+		return Identity{}, ErrUnavailable
+	}
+
+	var body ctfdResponse
+	decoder := json.NewDecoder(resp.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&body); err != nil {
+		return Identity{}, ErrUnavailable
+	}
+	if !body.Success {
+		return Identity{}, ErrInvalidToken
+	}
+
+	id := claimString(body.Data, a.field)
+	if id == "" {
+		return Identity{}, fmt.Errorf("%w: the field \"%s\" is missing", ErrInvalidToken, a.field)
+	}
+
+	identity := Identity{ID: id}
+	if a.priorityField != "" {
+		identity.Priority = claimInt(body.Data, a.priorityField)
+	}
+	return identity, nil
+}
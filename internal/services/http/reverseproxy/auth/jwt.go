@@ -0,0 +1,230 @@
+package auth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mart123p/ctf-reverseproxy/internal/config"
+)
+
+// Tolerated clock difference with the scoreboard when checking exp and nbf
+const jwtLeeway = 30 * time.Second
+
+type jwtAuthenticator struct {
+	algorithm string
+	secret    []byte         //HS256
+	publicKey *rsa.PublicKey //RS256
+	claim     string
+	teamClaim string
+	issuer    string
+
+	priorityClaim string
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+}
+
+func newJWTAuthenticator() *jwtAuthenticator {
+	a := &jwtAuthenticator{
+		algorithm: strings.ToUpper(config.GetString(config.CReverseProxyAuthJWTAlgorithm)),
+		claim:     config.GetString(config.CReverseProxyAuthJWTClaim),
+		teamClaim: config.GetString(config.CReverseProxyAuthJWTTeamClaim),
+		issuer:    config.GetString(config.CReverseProxyAuthJWTIssuer),
+
+		priorityClaim: config.GetString(config.CReverseProxyAuthJWTPriorityClaim),
+	}
+
+	key := []byte(config.GetString(config.CReverseProxyAuthJWTKey))
+	if keyFile := config.GetString(config.CReverseProxyAuthJWTKeyFile); keyFile != "" {
+		var err error
+		key, err = os.ReadFile(keyFile)
+		if err != nil {
+			log.Fatalf("[ReverseProxy] [Auth] -> Could not read the JWT key file \"%s\", %s", keyFile, err)
+		}
+	}
+	if len(key) == 0 {
+		log.Fatalf("[ReverseProxy] [Auth] -> The JWT key is not set")
+	}
+
+	switch a.algorithm {
+	case "HS256":
+		a.secret = bytes.TrimSpace(key)
+	case "RS256":
+		publicKey, err := parseRSAPublicKey(key)
+		if err != nil {
+			log.Fatalf("[ReverseProxy] [Auth] -> Could not parse the RS256 public key, %s", err)
+		}
+		a.publicKey = publicKey
+	default:
+		log.Fatalf("[ReverseProxy] [Auth] -> Unsupported JWT algorithm \"%s\". Use HS256 or RS256", a.algorithm)
+	}
+
+	return a
+}
+
+func (a *jwtAuthenticator) Authenticate(token string) (Identity, error) {
+	claims, err := a.verify(token)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	id := claimString(claims, a.claim)
+	if id == "" {
+		return Identity{}, fmt.Errorf("%w: the claim \"%s\" is missing", ErrInvalidToken, a.claim)
+	}
+
+	identity := Identity{ID: id}
+	if a.teamClaim != "" {
+		identity.Team = claimString(claims, a.teamClaim)
+	}
+	if a.priorityClaim != "" {
+		identity.Priority = claimInt(claims, a.priorityClaim)
+	}
+	return identity, nil
+}
+
+// verify checks the signature and the registered claims. Returns the claims of the token
+func (a *jwtAuthenticator) verify(token string) (map[string]interface{}, error) {
+	token = strings.TrimSpace(strings.TrimPrefix(token, "Bearer "))
+	if token == "" {
+		return nil, ErrMissingToken
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed token", ErrInvalidToken)
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: malformed header", ErrInvalidToken)
+	}
+
+	//The algorithm is fixed by the config. Never trust the one in the token
+	if header.Alg != a.algorithm {
+		return nil, fmt.Errorf("%w: unexpected algorithm \"%s\"", ErrInvalidToken, header.Alg)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed signature", ErrInvalidToken)
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	switch a.algorithm {
+	case "HS256":
+		mac := hmac.New(sha256.New, a.secret)
+		mac.Write(signed)
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return nil, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+	case "RS256":
+		digest := sha256.Sum256(signed)
+		if err := rsa.VerifyPKCS1v15(a.publicKey, crypto.SHA256, digest[:], signature); err != nil {
+			return nil, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: malformed claims", ErrInvalidToken)
+	}
+
+	now := time.Now()
+	if exp, ok := claimTime(claims, "exp"); ok && now.After(exp.Add(jwtLeeway)) {
+		return nil, fmt.Errorf("%w: token expired", ErrInvalidToken)
+	}
+	if nbf, ok := claimTime(claims, "nbf"); ok && now.Add(jwtLeeway).Before(nbf) {
+		return nil, fmt.Errorf("%w: token not valid yet", ErrInvalidToken)
+	}
+	if a.issuer != "" && claimString(claims, "iss") != a.issuer {
+		return nil, fmt.Errorf("%w: unexpected issuer", ErrInvalidToken)
+	}
+
+	return claims, nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// claimString returns the claim as a string. Numeric ids are supported
+func claimString(claims map[string]interface{}, name string) string {
+	switch value := claims[name].(type) {
+	case string:
+		return value
+	case json.Number:
+		return value.String()
+	}
+	return ""
+}
+
+// claimInt returns the claim as an int. Returns 0 if the claim is missing or not a number
+func claimInt(claims map[string]interface{}, name string) int {
+	value, err := strconv.Atoi(claimString(claims, name))
+	if err != nil {
+		return 0
+	}
+	return value
+}
+
+func claimTime(claims map[string]interface{}, name string) (time.Time, bool) {
+	value, ok := claims[name].(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	seconds, err := value.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(seconds), 0), true
+}
+
+func parseRSAPublicKey(data []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+
+	if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+		if key, ok := cert.PublicKey.(*rsa.PublicKey); ok {
+			return key, nil
+		}
+		return nil, fmt.Errorf("the certificate does not contain a RSA key")
+	}
+
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("the key is not a RSA key")
+	}
+	return rsaKey, nil
+}
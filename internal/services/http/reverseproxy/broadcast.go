@@ -3,8 +3,10 @@ package reverseproxy
 import "github.com/mart123p/ctf-reverseproxy/pkg/cbroadcast"
 
 const BProxyMetricTime = "proxy:metric:time"
+const BProxyMetricThrottled = "proxy:metric:throttled" // Reason why a request was throttled
 const BSize = 5
 
 func (rp *ReverseProxy) Register() {
 	cbroadcast.Register(BProxyMetricTime, BSize)
+	cbroadcast.Register(BProxyMetricThrottled, BSize)
 }
@@ -0,0 +1,121 @@
+package reverseproxy
+
+import (
+	"context"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/mart123p/ctf-reverseproxy/internal/config"
+	"github.com/mart123p/ctf-reverseproxy/internal/services/sessionmanager"
+)
+
+// Seconds the player is asked to wait before retrying once the instance is recycled
+const retryAfter = 5
+
+const defaultErrorPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+{{if .Restarting}}<meta http-equiv="refresh" content="{{.RetryAfter}}">{{end}}
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>{{.Message}}</p>
+<p><small>Request id: {{.RequestId}}</small></p>
+</body>
+</html>
+`
+
+type errorPageData struct {
+	Title      string
+	Message    string
+	RequestId  string
+	Restarting bool
+	RetryAfter int
+}
+
+type errorHandler struct {
+	page      *template.Template
+	threshold int
+
+	mu       sync.Mutex
+	failures map[string]int //Consecutive failures per container addr
+}
+
+func newErrorHandler() *errorHandler {
+	e := &errorHandler{
+		threshold: config.GetInt(config.CReverseProxyErrorThreshold),
+		failures:  make(map[string]int),
+	}
+
+	var err error
+	if path := config.GetString(config.CReverseProxyErrorPage); path != "" {
+		e.page, err = template.ParseFiles(path)
+	} else {
+		e.page, err = template.New("error").Parse(defaultErrorPage)
+	}
+	if err != nil {
+		log.Fatalf("[ReverseProxy] -> Could not load the error page, %s", err)
+	}
+
+	return e
+}
+
+// success resets the failure count of the container
+func (e *errorHandler) success(addr string) {
+	e.mu.Lock()
+	delete(e.failures, addr)
+	e.mu.Unlock()
+}
+
+// fail increments the failure count of the container. Returns true once the threshold is reached
+func (e *errorHandler) fail(addr string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.failures[addr]++
+	if e.threshold > 0 && e.failures[addr] >= e.threshold {
+		delete(e.failures, addr)
+		return true
+	}
+	return false
+}
+
+// serve renders the error page when the container of the session cannot be reached
+func (e *errorHandler) serve(w http.ResponseWriter, err error, sessionHash string, addr string, requestId string) {
+	//The player closed the connection, the container is not at fault
+	if errors.Is(err, context.Canceled) {
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+
+	log.Printf("[ReverseProxy] -> Container unreachable | Session: %s | Container Addr: %s | %s", sessionHash, addr, err)
+
+	data := errorPageData{
+		Title:      "Instance unreachable",
+		Message:    "Your instance is not responding. Please try again in a few seconds.",
+		RequestId:  requestId,
+		RetryAfter: retryAfter,
+	}
+	status := http.StatusBadGateway
+
+	if e.fail(addr) && sessionmanager.RecycleSession(sessionHash, addr) {
+		data.Title = "Instance restarting"
+		data.Message = "Your instance is being restarted. This page will reload automatically."
+		data.Restarting = true
+		status = http.StatusServiceUnavailable
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := e.page.Execute(w, data); err != nil {
+		log.Printf("Warning: [ReverseProxy] -> Could not render the error page, %s", err)
+	}
+}
@@ -0,0 +1,614 @@
+package reverseproxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mart123p/ctf-reverseproxy/internal/config"
+	"github.com/mart123p/ctf-reverseproxy/pkg/filename"
+)
+
+var ErrCaptureDisabled = errors.New("the capture directory is not set")
+var ErrCaptureNotFound = errors.New("capture not found")
+
+// The entries are written before the footer so the file is always a valid HAR document
+const harHeader = `{"log":{"version":"1.2","creator":{"name":"ctf-reverseproxy","version":"1.0"},"entries":[` + "\n"
+const harFooter = "\n]}}\n"
+
+const redactedValue = "[redacted]"
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType  string `json:"mimeType"`
+	Text      string `json:"text"`
+	Encoding  string `json:"_encoding,omitempty"`
+	Truncated bool   `json:"_truncated,omitempty"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harContent struct {
+	Size      int64  `json:"size"`
+	MimeType  string `json:"mimeType"`
+	Text      string `json:"text,omitempty"`
+	Encoding  string `json:"encoding,omitempty"`
+	Truncated bool   `json:"_truncated,omitempty"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harTimings struct {
+	Blocked float64 `json:"blocked"` //Time waited for an instance
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	RequestId       string      `json:"_requestId"`
+	Instance        string      `json:"_instance"`
+	Error           string      `json:"_error,omitempty"`
+}
+
+// CaptureFile is a HAR file of a session
+type CaptureFile struct {
+	SessionHash string
+	Name        string
+	Size        int64
+	Time        int64 //Unix time of the last entry
+}
+
+// harCapture writes the traffic of the sessions in HAR files
+type harCapture struct {
+	dir      string
+	bodySize int64
+	maxSize  int64
+	maxFiles int
+	redact   map[string]bool //Canonical names of the redacted headers
+
+	lock      sync.Mutex
+	files     map[string]*harFile //Current file of the sessions
+	idle      time.Duration       //Files not written for this long are forgotten
+	lastSweep time.Time
+}
+
+// harFile is the file receiving the entries of a session
+type harFile struct {
+	lock      sync.Mutex
+	path      string
+	size      int64
+	entries   int
+	lastWrite time.Time
+}
+
+// Used by the management api to read the files being written
+var capture *harCapture
+
+// newHarCapture returns nil when the capture is disabled
+func newHarCapture(sessionHeader string) *harCapture {
+	dir := config.GetString(config.CReverseProxyCaptureDir)
+	if dir == "" {
+		return nil
+	}
+
+	c := &harCapture{
+		dir:      dir,
+		bodySize: config.GetInt64(config.CReverseProxyCaptureBodySize),
+		maxSize:  config.GetInt64(config.CReverseProxyCaptureMaxSize) * 1024 * 1024,
+		maxFiles: config.GetInt(config.CReverseProxyCaptureMaxFiles),
+		redact:   make(map[string]bool),
+		files:    make(map[string]*harFile),
+		idle:     time.Duration(config.GetInt64(config.CReverseProxySessionTimeout)) * time.Second,
+	}
+
+	//The session token and the management key are always redacted
+	for _, name := range append([]string{sessionHeader, mgmtKeyHeader}, config.GetStringSlice(config.CReverseProxyCaptureRedact)...) {
+		c.redact[http.CanonicalHeaderKey(name)] = true
+	}
+
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		log.Fatalf("[ReverseProxy] -> Could not create the capture directory \"%s\", %s", dir, err)
+	}
+	log.Printf("[ReverseProxy] -> Traffic captured in \"%s\" | Body size: %d", dir, c.bodySize)
+
+	capture = c
+	return c
+}
+
+// headers returns the headers sorted by name with the redacted values replaced
+func (c *harCapture) headers(header http.Header) []harNameValue {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	values := make([]harNameValue, 0, len(header))
+	for _, name := range names {
+		for _, value := range header[name] {
+			if c.redact[http.CanonicalHeaderKey(name)] {
+				value = redactedValue
+			}
+			values = append(values, harNameValue{Name: name, Value: value})
+		}
+	}
+	return values
+}
+
+// record starts the capture of a request. The request body is captured while the reverse proxy reads it
+func (c *harCapture) record(r *http.Request, sessionHash string, requestId string) *harRecorder {
+	h := &harRecorder{
+		c:           c,
+		sessionHash: sessionHash,
+		start:       time.Now(),
+		response:    &cappedBuffer{limit: c.bodySize},
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	query := r.URL.Query()
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	queryString := make([]harNameValue, 0, len(query))
+	for _, name := range names {
+		for _, value := range query[name] {
+			queryString = append(queryString, harNameValue{Name: name, Value: value})
+		}
+	}
+
+	//The host is not part of the headers of a server request
+	headers := append([]harNameValue{{Name: "Host", Value: r.Host}}, c.headers(r.Header)...)
+
+	h.entry = harEntry{
+		StartedDateTime: h.start.UTC().Format(time.RFC3339Nano),
+		RequestId:       requestId,
+		Request: harRequest{
+			Method:      r.Method,
+			URL:         scheme + "://" + r.Host + r.URL.RequestURI(),
+			HTTPVersion: r.Proto,
+			Cookies:     []harNameValue{},
+			Headers:     headers,
+			QueryString: queryString,
+			HeadersSize: -1,
+		},
+	}
+
+	if r.Body != nil && r.Body != http.NoBody {
+		h.request = &cappedBuffer{limit: c.bodySize}
+		r.Body = &teeBody{ReadCloser: r.Body, capture: h.request}
+	}
+	return h
+}
+
+// write appends the entry to the current file of the session
+func (c *harCapture) write(sessionHash string, entry *harEntry) {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("Warning: [ReverseProxy] -> Could not marshal capture entry, %s", err)
+		return
+	}
+
+	c.lock.Lock()
+	c.sweep()
+	f, ok := c.files[sessionHash]
+	if !ok {
+		f = &harFile{}
+		c.files[sessionHash] = f
+	}
+	c.lock.Unlock()
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.lastWrite = time.Now()
+	if err := f.append(line, filepath.Join(c.dir, sessionHash), c.maxSize, c.maxFiles); err != nil {
+		log.Printf("Warning: [ReverseProxy] -> Could not write the capture of the session %s, %s", sessionHash, err)
+	}
+}
+
+// sweep forgets the files of the sessions that ended. Their files are complete on disk and a returning session starts a new one
+func (c *harCapture) sweep() {
+	now := time.Now()
+	if now.Sub(c.lastSweep) < time.Minute {
+		return
+	}
+	c.lastSweep = now
+
+	for sessionHash, f := range c.files {
+		f.lock.Lock()
+		idle := now.Sub(f.lastWrite) > c.idle
+		f.lock.Unlock()
+		if idle {
+			delete(c.files, sessionHash)
+		}
+	}
+}
+
+// append writes the entry over the footer. A new file is started when the current one is full
+func (f *harFile) append(line []byte, dir string, maxSize int64, maxFiles int) error {
+	if f.path == "" || (f.entries > 0 && f.size+int64(len(line)) > maxSize) {
+		if err := f.create(dir, maxFiles); err != nil {
+			return err
+		}
+	}
+
+	data := make([]byte, 0, len(line)+len(harFooter)+2)
+	if f.entries > 0 {
+		data = append(data, ",\n"...)
+	}
+	data = append(data, line...)
+	data = append(data, harFooter...)
+
+	file, err := os.OpenFile(f.path, os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	offset := f.size - int64(len(harFooter))
+	if _, err := file.WriteAt(data, offset); err != nil {
+		return err
+	}
+	f.size = offset + int64(len(data))
+	f.entries++
+	return nil
+}
+
+// create starts a new file for the session and removes the oldest ones
+func (f *harFile) create(dir string, maxFiles int) error {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("%d.har", time.Now().UnixNano()))
+	if err := os.WriteFile(path, []byte(harHeader+harFooter), 0640); err != nil {
+		return err
+	}
+	f.path = path
+	f.size = int64(len(harHeader) + len(harFooter))
+	f.entries = 0
+
+	names, err := harFiles(dir)
+	if err != nil {
+		return err
+	}
+	for len(names) > maxFiles {
+		os.Remove(filepath.Join(dir, names[0]))
+		names = names[1:]
+	}
+	return nil
+}
+
+// harFiles returns the names of the HAR files in dir, the oldest first
+func harFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".har") {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// harRecorder captures a request and its response
+type harRecorder struct {
+	c           *harCapture
+	sessionHash string
+	start       time.Time
+	entry       harEntry
+	request     *cappedBuffer
+	response    *cappedBuffer
+}
+
+// wrap captures the body of the response sent to the player
+func (h *harRecorder) wrap(w http.ResponseWriter) http.ResponseWriter {
+	return &captureWriter{ResponseWriter: w, body: h.response}
+}
+
+// finish completes the entry with the response and writes it
+func (h *harRecorder) finish(w *accessWriter, access *accessEntry) {
+	e := &h.entry
+	e.Time = toMilliseconds(time.Since(h.start))
+	e.Instance = access.Instance
+	e.Error = access.Error
+	e.Timings = harTimings{
+		Blocked: access.QueueMs,
+		Wait:    access.UpstreamMs,
+		Receive: e.Time - access.QueueMs - access.UpstreamMs,
+	}
+	if e.Timings.Receive < 0 {
+		e.Timings.Receive = 0
+	}
+
+	if h.request != nil {
+		text, encoding := h.request.text()
+		e.Request.BodySize = h.request.total()
+		e.Request.PostData = &harPostData{
+			MimeType:  e.Request.headerValue("Content-Type"),
+			Text:      text,
+			Encoding:  encoding,
+			Truncated: h.request.truncated(),
+		}
+	}
+
+	header := w.Header()
+	text, encoding := h.response.text()
+	e.Response = harResponse{
+		Status:      w.status,
+		StatusText:  http.StatusText(w.status),
+		HTTPVersion: e.Request.HTTPVersion,
+		Cookies:     []harNameValue{},
+		Headers:     h.c.headers(header),
+		Content: harContent{
+			Size:      h.response.total(),
+			MimeType:  header.Get("Content-Type"),
+			Text:      text,
+			Encoding:  encoding,
+			Truncated: h.response.truncated(),
+		},
+		RedirectURL: header.Get("Location"),
+		HeadersSize: -1,
+		BodySize:    w.bytes,
+	}
+
+	h.c.write(h.sessionHash, e)
+}
+
+func (r *harRequest) headerValue(name string) string {
+	for _, header := range r.Headers {
+		if http.CanonicalHeaderKey(header.Name) == name {
+			return header.Value
+		}
+	}
+	return ""
+}
+
+// cappedBuffer keeps the first bytes of a body and counts the rest
+type cappedBuffer struct {
+	lock  sync.Mutex
+	buf   bytes.Buffer
+	limit int64
+	size  int64
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.size += int64(len(p))
+	if room := b.limit - int64(b.buf.Len()); room > 0 {
+		if int64(len(p)) > room {
+			b.buf.Write(p[:room])
+		} else {
+			b.buf.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+func (b *cappedBuffer) total() int64 {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.size
+}
+
+func (b *cappedBuffer) truncated() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.size > int64(b.buf.Len())
+}
+
+// text returns the body as text. Binary bodies are encoded in base64
+func (b *cappedBuffer) text() (string, string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	data := b.buf.Bytes()
+	if utf8.Valid(data) {
+		return string(data), ""
+	}
+	return base64.StdEncoding.EncodeToString(data), "base64"
+}
+
+// teeBody copies the request body read by the reverse proxy
+type teeBody struct {
+	io.ReadCloser
+	capture *cappedBuffer
+}
+
+func (t *teeBody) Read(p []byte) (int, error) {
+	n, err := t.ReadCloser.Read(p)
+	t.capture.Write(p[:n])
+	return n, err
+}
+
+// captureWriter copies the response body sent to the player
+type captureWriter struct {
+	http.ResponseWriter
+	body *cappedBuffer
+}
+
+func (w *captureWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.body.Write(p[:n])
+	return n, err
+}
+
+func (w *captureWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack is required for protocol upgrades such as websockets. The upgraded traffic is not captured
+func (w *captureWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hijacker, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return hijacker.Hijack()
+	}
+	return nil, nil, errors.New("hijack is not supported")
+}
+
+func (w *captureWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// GetCaptures returns the HAR files of the session hash, the oldest first. The files of every session are returned when it is empty
+func GetCaptures(sessionHash string) ([]CaptureFile, error) {
+	if capture == nil {
+		return nil, ErrCaptureDisabled
+	}
+
+	sessionHashes := []string{sessionHash}
+	if sessionHash == "" {
+		entries, err := os.ReadDir(capture.dir)
+		if err != nil {
+			return nil, err
+		}
+		sessionHashes = sessionHashes[:0]
+		for _, entry := range entries {
+			if entry.IsDir() {
+				sessionHashes = append(sessionHashes, entry.Name())
+			}
+		}
+	} else if !filename.Valid(sessionHash) {
+		return []CaptureFile{}, nil
+	}
+
+	files := make([]CaptureFile, 0)
+	for _, sessionHash := range sessionHashes {
+		names, err := harFiles(filepath.Join(capture.dir, sessionHash))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			info, err := os.Stat(filepath.Join(capture.dir, sessionHash, name))
+			if err != nil {
+				//Removed by a rotation
+				continue
+			}
+			files = append(files, CaptureFile{
+				SessionHash: sessionHash,
+				Name:        name,
+				Size:        info.Size(),
+				Time:        info.ModTime().Unix(),
+			})
+		}
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].Name < files[j].Name
+	})
+	return files, nil
+}
+
+// captureReader reads a HAR file up to its last complete entry
+type captureReader struct {
+	io.Reader
+	file *os.File
+}
+
+func (r *captureReader) Close() error {
+	return r.file.Close()
+}
+
+// OpenCapture opens a HAR file of a session. The file can be read while new entries are written. Returns its size
+func OpenCapture(sessionHash string, name string) (io.ReadCloser, int64, error) {
+	if capture == nil {
+		return nil, 0, ErrCaptureDisabled
+	}
+	if !filename.Valid(sessionHash) || !filename.Valid(name) || !strings.HasSuffix(name, ".har") {
+		return nil, 0, ErrCaptureNotFound
+	}
+
+	path := filepath.Join(capture.dir, sessionHash, name)
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, ErrCaptureNotFound
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	size := info.Size()
+
+	//The entries before the footer of the current file are never rewritten
+	capture.lock.Lock()
+	current, ok := capture.files[sessionHash]
+	capture.lock.Unlock()
+	if ok {
+		current.lock.Lock()
+		if current.path == path {
+			size = current.size
+		}
+		current.lock.Unlock()
+	}
+
+	if size < int64(len(harHeader)+len(harFooter)) {
+		file.Close()
+		return nil, 0, ErrCaptureNotFound
+	}
+	content := size - int64(len(harFooter))
+	return &captureReader{
+		Reader: io.MultiReader(io.NewSectionReader(file, 0, content), strings.NewReader(harFooter)),
+		file:   file,
+	}, size, nil
+}
@@ -0,0 +1,111 @@
+package reverseproxy
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/mart123p/ctf-reverseproxy/internal/config"
+)
+
+// Header used by the management interface. It must never reach a challenge container
+const mgmtKeyHeader = "X-Management-Key"
+
+type headerPolicy struct {
+	forwarded      bool
+	instanceHeader string
+
+	requestAdd    map[string]string
+	requestRemove []string
+
+	responseAdd     map[string]string
+	responseRemove  []string
+	responseRewrite bool
+}
+
+func newHeaderPolicy(sessionHeader string) headerPolicy {
+	h := headerPolicy{
+		forwarded:       config.GetBool(config.CReverseProxyHeadersForwarded),
+		instanceHeader:  config.GetString(config.CReverseProxyHeadersInstance),
+		requestAdd:      config.GetStringMapString(config.CReverseProxyHeadersRequestAdd),
+		responseAdd:     config.GetStringMapString(config.CReverseProxyHeadersResponseAdd),
+		responseRemove:  config.GetStringSlice(config.CReverseProxyHeadersResponseRemove),
+		responseRewrite: config.GetBool(config.CReverseProxyHeadersResponseRewrite),
+	}
+
+	//The session token and the management key are always stripped
+	h.requestRemove = append([]string{sessionHeader, mgmtKeyHeader}, config.GetStringSlice(config.CReverseProxyHeadersRequestRemove)...)
+	return h
+}
+
+// rewriteRequest applies the policy to the request sent to the challenge container
+func (h *headerPolicy) rewriteRequest(req *http.Request, targetHost string) {
+	for _, name := range h.requestRemove {
+		req.Header.Del(name)
+	}
+
+	if h.forwarded {
+		proto := "http"
+		if req.TLS != nil {
+			proto = "https"
+		}
+
+		//X-Forwarded-For is appended by httputil.ReverseProxy
+		req.Header.Set("X-Forwarded-Host", req.Host)
+		req.Header.Set("X-Forwarded-Proto", proto)
+
+		forwarded := "proto=" + proto
+		if req.Host != "" {
+			forwarded = "host=\"" + req.Host + "\";" + forwarded
+		}
+		if clientIP, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+			if strings.Contains(clientIP, ":") {
+				clientIP = "\"[" + clientIP + "]\""
+			}
+			forwarded = "for=" + clientIP + ";" + forwarded
+		}
+		req.Header.Set("Forwarded", forwarded)
+	}
+
+	if h.instanceHeader != "" {
+		req.Header.Set(h.instanceHeader, getInstanceId(targetHost))
+	}
+
+	for name, value := range h.requestAdd {
+		req.Header.Set(name, value)
+	}
+}
+
+// rewriteResponse applies the policy to the response sent back to the player
+func (h *headerPolicy) rewriteResponse(resp *http.Response, publicHost string, targetHost string) {
+	for _, name := range h.responseRemove {
+		resp.Header.Del(name)
+	}
+
+	if h.responseRewrite && publicHost != "" {
+		publicHostname := publicHost
+		if host, _, err := net.SplitHostPort(publicHost); err == nil {
+			publicHostname = host
+		}
+		replacer := strings.NewReplacer(targetHost, publicHost, getInstanceId(targetHost), publicHostname)
+
+		for name, values := range resp.Header {
+			for i, value := range values {
+				values[i] = replacer.Replace(value)
+			}
+			resp.Header[name] = values
+		}
+	}
+
+	for name, value := range h.responseAdd {
+		resp.Header.Set(name, value)
+	}
+}
+
+// getInstanceId returns the hostname of the main container of an instance
+func getInstanceId(targetHost string) string {
+	if host, _, err := net.SplitHostPort(targetHost); err == nil {
+		return host
+	}
+	return targetHost
+}
@@ -0,0 +1,80 @@
+package reverseproxy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mart123p/ctf-reverseproxy/internal/config"
+)
+
+// Header containing the unix time when the instance of the session is destroyed
+const instanceExpiresHeader = "X-Instance-Expires"
+
+// Largest html page in which the banner is injected
+const maxBannerBody = 5 * 1024 * 1024
+
+const bannerTemplate = `<div id="ctf-reverseproxy-banner" style="position:fixed;bottom:0;left:0;right:0;z-index:2147483647;padding:8px;background:#b00020;color:#fff;font:14px sans-serif;text-align:center">Your instance will be destroyed in <span id="ctf-reverseproxy-countdown">%d</span> seconds.</div>
+<script>(function(){var end=%d;var el=document.getElementById("ctf-reverseproxy-countdown");setInterval(function(){el.textContent=Math.max(0,end-Math.floor(Date.now()/1000));},1000);})();</script>
+`
+
+type lifetimeWarning struct {
+	warning int64
+	banner  bool
+}
+
+func newLifetimeWarning() lifetimeWarning {
+	return lifetimeWarning{
+		warning: config.GetInt64(config.CReverseProxySessionWarning),
+		banner:  config.GetBool(config.CReverseProxySessionBanner),
+	}
+}
+
+// apply surfaces the end of the session to the player
+func (l *lifetimeWarning) apply(resp *http.Response, endsOn int64) {
+	if endsOn == 0 {
+		return
+	}
+
+	resp.Header.Set(instanceExpiresHeader, strconv.FormatInt(endsOn, 10))
+
+	remaining := endsOn - time.Now().Unix()
+	if !l.banner || remaining > l.warning {
+		return
+	}
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	//Compressed or large bodies are left untouched
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") || resp.Header.Get("Content-Encoding") != "" || resp.ContentLength > maxBannerBody {
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBannerBody+1))
+	if err != nil || len(body) > maxBannerBody {
+		resp.Body = readCloser{Reader: io.MultiReader(bytes.NewReader(body), resp.Body), Closer: resp.Body}
+		return
+	}
+	resp.Body.Close()
+
+	banner := []byte(fmt.Sprintf(bannerTemplate, remaining, endsOn))
+	if index := bytes.LastIndex(bytes.ToLower(body), []byte("</body>")); index != -1 {
+		body = append(body[:index], append(banner, body[index:]...)...)
+	} else {
+		body = append(body, banner...)
+	}
+
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
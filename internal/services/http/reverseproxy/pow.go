@@ -0,0 +1,221 @@
+package reverseproxy
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"html/template"
+	"log"
+	"math/bits"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mart123p/ctf-reverseproxy/internal/config"
+)
+
+// Proof of work protocol. The challenge is sent in the response headers and the solution is sent back
+// either in the solution header or in the cookie as "<challenge>:<nonce>"
+const powChallengeHeader = "X-PoW-Challenge"
+const powDifficultyHeader = "X-PoW-Difficulty"
+const powSolutionHeader = "X-PoW-Solution"
+const powCookie = "ctf_pow"
+
+// Time during which a challenge can be solved
+const powChallengeTTL = 10 * time.Minute
+
+// Time during which a used solution is still accepted. Covers the requests sent at once by the page that solved it
+const powReuseGrace = 10 * time.Second
+
+//go:embed pow.html
+var powPage string
+
+type proofOfWork struct {
+	difficulty int
+	secret     []byte //Used to sign the challenges
+	page       *template.Template
+
+	mu        sync.Mutex
+	used      map[string]usedChallenge //Signature of the solved challenges
+	lastSweep time.Time
+}
+
+type usedChallenge struct {
+	graceEnd time.Time
+	expires  int64
+}
+
+// newProofOfWork returns nil when the proof of work is disabled
+func newProofOfWork() *proofOfWork {
+	difficulty := config.GetInt(config.CReverseProxyPowDifficulty)
+	if difficulty <= 0 {
+		return nil
+	}
+	if difficulty > 256 {
+		log.Fatalf("[ReverseProxy] -> The proof of work difficulty must be at most 256 bits")
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+
+	log.Printf("[ReverseProxy] -> Proof of work enabled | Difficulty: %d bits", difficulty)
+	return &proofOfWork{
+		difficulty: difficulty,
+		secret:     secret,
+		page:       template.Must(template.New("pow").Parse(powPage)),
+		used:       make(map[string]usedChallenge),
+	}
+}
+
+// newChallenge returns a challenge bound to the session. Format: <expires>.<random>.<signature>
+func (p *proofOfWork) newChallenge(sessionHash string) string {
+	random := make([]byte, 8)
+	if _, err := rand.Read(random); err != nil {
+		panic(err)
+	}
+
+	payload := strconv.FormatInt(time.Now().Add(powChallengeTTL).Unix(), 10) + "." + hex.EncodeToString(random)
+	return payload + "." + p.sign(payload, sessionHash)
+}
+
+func (p *proofOfWork) sign(payload string, sessionHash string) string {
+	mac := hmac.New(sha256.New, p.secret)
+	mac.Write([]byte(payload + "." + sessionHash))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verify checks the solution sent with the request. The challenge is bound to the session hash and a solution only creates one session
+func (p *proofOfWork) verify(r *http.Request, sessionHash string) bool {
+	solution := r.Header.Get(powSolutionHeader)
+	if solution == "" {
+		if cookie, err := r.Cookie(powCookie); err == nil {
+			solution = cookie.Value
+		}
+	}
+	if solution == "" {
+		return false
+	}
+
+	sep := strings.LastIndex(solution, ":")
+	if sep == -1 {
+		return false
+	}
+	challenge := solution[:sep]
+
+	parts := strings.Split(challenge, ".")
+	if len(parts) != 3 {
+		return false
+	}
+
+	payload := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(parts[2]), []byte(p.sign(payload, sessionHash))) {
+		return false
+	}
+
+	expires, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || expires < time.Now().Unix() {
+		return false
+	}
+
+	hash := sha256.Sum256([]byte(solution))
+	if leadingZeroBits(hash[:]) < p.difficulty {
+		return false
+	}
+	return p.consume(parts[2], expires)
+}
+
+// consume marks the challenge as used. It is accepted again only during the grace period
+func (p *proofOfWork) consume(signature string, expires int64) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(p.lastSweep) > time.Minute {
+		for key, use := range p.used {
+			if use.expires < now.Unix() {
+				delete(p.used, key)
+			}
+		}
+		p.lastSweep = now
+	}
+
+	if use, ok := p.used[signature]; ok {
+		return now.Before(use.graceEnd)
+	}
+
+	//Kept until the challenge expires so the solution cannot be used again
+	p.used[signature] = usedChallenge{
+		graceEnd: now.Add(powReuseGrace),
+		expires:  expires,
+	}
+	return true
+}
+
+// serve sends a new challenge. Browsers get a page that solves it in javascript
+func (p *proofOfWork) serve(w http.ResponseWriter, sessionHash string) {
+	challenge := p.newChallenge(sessionHash)
+
+	w.Header().Set(powChallengeHeader, challenge)
+	w.Header().Set(powDifficultyHeader, strconv.Itoa(p.difficulty))
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusForbidden)
+
+	err := p.page.Execute(w, struct {
+		Challenge  string
+		Difficulty int
+		Cookie     string
+		TTL        int
+	}{
+		Challenge:  challenge,
+		Difficulty: p.difficulty,
+		Cookie:     powCookie,
+		TTL:        int(powChallengeTTL.Seconds()),
+	})
+	if err != nil {
+		log.Printf("Warning: [ReverseProxy] -> Could not render the proof of work page, %s", err)
+	}
+}
+
+// strip removes the solution from the request sent to the challenge
+func (p *proofOfWork) strip(req *http.Request) {
+	req.Header.Del(powSolutionHeader)
+
+	cookies := req.Cookies()
+	if len(cookies) == 0 {
+		return
+	}
+
+	kept := make([]string, 0, len(cookies))
+	for _, cookie := range cookies {
+		if cookie.Name != powCookie {
+			kept = append(kept, cookie.Name+"="+cookie.Value)
+		}
+	}
+
+	if len(kept) == len(cookies) {
+		return
+	}
+	if len(kept) == 0 {
+		req.Header.Del("Cookie")
+		return
+	}
+	req.Header.Set("Cookie", strings.Join(kept, "; "))
+}
+
+func leadingZeroBits(hash []byte) int {
+	count := 0
+	for _, b := range hash {
+		if b != 0 {
+			return count + bits.LeadingZeros8(b)
+		}
+		count += 8
+	}
+	return count
+}
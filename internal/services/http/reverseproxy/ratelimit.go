@@ -0,0 +1,285 @@
+package reverseproxy
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mart123p/ctf-reverseproxy/internal/config"
+	"github.com/mart123p/ctf-reverseproxy/pkg/cbroadcast"
+)
+
+// Reasons reported in the throttled requests metric
+const (
+	ThrottleIP        = "ip"
+	ThrottleSession   = "session"
+	ThrottleBody      = "body"
+	ThrottleBandwidth = "bandwidth"
+)
+
+// Largest write done at once when the bandwidth is throttled
+const maxThrottleChunk = 32 * 1024
+
+// Idle buckets are removed from memory after this interval
+const sweepInterval = time.Minute
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// limiter is a set of token buckets identified by a key
+type limiter struct {
+	mu    sync.Mutex
+	rate  float64 //Tokens added per second
+	burst float64 //Size of the bucket
+
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// newLimiter returns nil when the rate is disabled
+func newLimiter(rate float64, burst float64) *limiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = rate
+		if burst < 1 {
+			burst = 1
+		}
+	}
+
+	return &limiter{
+		rate:      rate,
+		burst:     burst,
+		buckets:   make(map[string]*bucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// refill returns the bucket of the key with the tokens added since the last call. The lock must be held
+func (l *limiter) refill(key string, now time.Time) *bucket {
+	if now.Sub(l.lastSweep) > sweepInterval {
+		for k, b := range l.buckets {
+			if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+		return b
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+	return b
+}
+
+// allow takes one token if one is available
+func (l *limiter) allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.refill(key, time.Now())
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// reserve takes n tokens and returns how long the caller must wait before using them
+func (l *limiter) reserve(key string, n float64) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.refill(key, time.Now())
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / l.rate * float64(time.Second))
+}
+
+type rateLimits struct {
+	ip        *limiter
+	session   *limiter
+	bandwidth *limiter
+	maxBody   int64
+}
+
+func newRateLimits() rateLimits {
+	return rateLimits{
+		ip: newLimiter(config.GetFloat64(config.CReverseProxyRateLimitIPRate),
+			config.GetFloat64(config.CReverseProxyRateLimitIPBurst)),
+		session: newLimiter(config.GetFloat64(config.CReverseProxyRateLimitSessionRate),
+			config.GetFloat64(config.CReverseProxyRateLimitSessionBurst)),
+		bandwidth: newLimiter(config.GetFloat64(config.CReverseProxyRateLimitBandwidth), 0),
+		maxBody:   config.GetInt64(config.CReverseProxyRateLimitBody),
+	}
+}
+
+// checkClient returns the reason why the request of the client must be rejected. An empty string means that the request is allowed
+func (rl *rateLimits) checkClient(r *http.Request, clientIP string) string {
+	if rl.ip != nil && !rl.ip.allow(clientIP) {
+		return ThrottleIP
+	}
+	if rl.maxBody > 0 && r.ContentLength > rl.maxBody {
+		return ThrottleBody
+	}
+	return ""
+}
+
+// checkSession returns the reason why the request of the session must be rejected. An empty string means that the request is allowed
+func (rl *rateLimits) checkSession(sessionHash string) string {
+	if rl.session != nil && !rl.session.allow(sessionHash) {
+		return ThrottleSession
+	}
+	return ""
+}
+
+// reject answers the request that was throttled
+func (rl *rateLimits) reject(w http.ResponseWriter, reason string) {
+	cbroadcast.Broadcast(BProxyMetricThrottled, reason)
+
+	if reason == ThrottleBody {
+		http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	w.Header().Set("Retry-After", "1")
+	http.Error(w, "Too many requests", http.StatusTooManyRequests)
+}
+
+// limitBody caps the size of request bodies that have no content length. Returns nil when there is no limit
+func (rl *rateLimits) limitBody(r *http.Request) *limitedBody {
+	if rl.maxBody <= 0 || r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+	body := &limitedBody{ReadCloser: r.Body, remaining: rl.maxBody}
+	r.Body = body
+	return body
+}
+
+// throttle caps the download bandwidth of the session
+func (rl *rateLimits) throttle(w http.ResponseWriter, r *http.Request, sessionHash string) http.ResponseWriter {
+	if rl.bandwidth == nil {
+		return w
+	}
+	return &throttledWriter{
+		ResponseWriter: w,
+		limiter:        rl.bandwidth,
+		key:            sessionHash,
+		done:           r.Context().Done(),
+	}
+}
+
+var errBodyTooLarge = errors.New("request body too large")
+
+// limitedBody fails once more than the allowed bytes are read
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+	exceeded  int32 //Set atomically since the body is read by the transport goroutine
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining < 0 {
+		return 0, errBodyTooLarge
+	}
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		atomic.StoreInt32(&b.exceeded, 1)
+		return n, errBodyTooLarge
+	}
+	return n, err
+}
+
+func (b *limitedBody) isExceeded() bool {
+	return atomic.LoadInt32(&b.exceeded) == 1
+}
+
+// throttledWriter delays the writes to respect the bandwidth of the session
+type throttledWriter struct {
+	http.ResponseWriter
+	limiter   *limiter
+	key       string
+	done      <-chan struct{}
+	throttled bool
+}
+
+func (w *throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		chunk := len(p) - written
+		if chunk > maxThrottleChunk {
+			chunk = maxThrottleChunk
+		}
+
+		if wait := w.limiter.reserve(w.key, float64(chunk)); wait > 0 {
+			if !w.throttled {
+				w.throttled = true
+				cbroadcast.Broadcast(BProxyMetricThrottled, ThrottleBandwidth)
+			}
+
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-w.done:
+				timer.Stop()
+				return written, context.Canceled
+			}
+		}
+
+		n, err := w.ResponseWriter.Write(p[written : written+chunk])
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+func (w *throttledWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *throttledWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hijacker, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return hijacker.Hijack()
+	}
+	return nil, nil, errors.New("hijack is not supported")
+}
+
+func (w *throttledWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// formatLimit is used to log the configured limits
+func formatLimit(l *limiter) string {
+	if l == nil {
+		return "disabled"
+	}
+	return strconv.FormatFloat(l.rate, 'f', -1, 64) + "/s"
+}
@@ -2,13 +2,16 @@ package reverseproxy
 
 import (
 	"context"
+	"errors"
 	"log"
 	"net/http"
 	"net/http/httputil"
+	"sync/atomic"
 	"time"
 
 	"github.com/mart123p/ctf-reverseproxy/internal/config"
 	service "github.com/mart123p/ctf-reverseproxy/internal/services"
+	"github.com/mart123p/ctf-reverseproxy/internal/services/http/reverseproxy/auth"
 	"github.com/mart123p/ctf-reverseproxy/internal/services/sessionmanager"
 	"github.com/mart123p/ctf-reverseproxy/pkg/cbroadcast"
 )
@@ -16,19 +19,107 @@ import (
 type ReverseProxy struct {
 	h             *http.Server
 	sessionHeader string
+	headers       headerPolicy
+	accessLog     *accessLogger
+	capture       *harCapture
+	errorPage     *errorHandler
+	limits        rateLimits
+	pow           *proofOfWork
+	auth          auth.Authenticator
+	lifetime      lifetimeWarning
 }
 
 func (rp *ReverseProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
+	requestId := newRequestId()
+
+	entry := accessEntry{
+		Time:      time.Now().UTC().Format(time.RFC3339Nano),
+		RequestId: requestId,
+		ClientIP:  getClientIP(r),
+		Method:    r.Method,
+		Host:      r.Host,
+		Path:      r.URL.Path,
+	}
+
+	var body *countingReader
+	if r.Body != nil && r.Body != http.NoBody {
+		body = &countingReader{ReadCloser: r.Body}
+		r.Body = body
+	}
+	aw := &accessWriter{ResponseWriter: w}
+
+	//The entry is written even if the reverse proxy aborts the handler
+	defer func() {
+		entry.Status = aw.status
+		entry.BytesOut = aw.bytes
+		if body != nil {
+			entry.BytesIn = atomic.LoadInt64(&body.bytes)
+		}
+		rp.accessLog.write(&entry)
+	}()
+
+	if reason := rp.limits.checkClient(r, entry.ClientIP); reason != "" {
+		entry.Error = "throttled: " + reason
+		rp.limits.reject(aw, reason)
+		return
+	}
+
 	sessionId := r.Header.Get(rp.sessionHeader)
+	priority := 0
+	if rp.auth != nil {
+		identity, err := rp.auth.Authenticate(sessionId)
+		if err != nil {
+			entry.Error = err.Error()
+			rejectAuth(aw, err)
+			return
+		}
+		sessionId = identity.ID
+		priority = identity.Priority
+
+		if identity.Team != "" {
+			sessionmanager.AddTeamMember(identity.Team, identity.ID)
+		}
+	}
+
+	//Members of a team share the same session
+	sessionId = sessionmanager.Resolve(sessionId)
 	sessionHash := sessionmanager.GetHash(sessionId)
+	entry.Session = sessionHash
+
+	if reason := rp.limits.checkSession(sessionHash); reason != "" {
+		entry.Error = "throttled: " + reason
+		rp.limits.reject(aw, reason)
+		return
+	}
+
+	//Creating a session is free while an instance is expensive. New sessions must solve a challenge first
+	if rp.pow != nil && !sessionmanager.SessionExists(sessionHash) && !rp.pow.verify(r, sessionHash) {
+		entry.Error = "proof of work required"
+		rp.pow.serve(aw, sessionHash)
+		return
+	}
+	limitedBody := rp.limits.limitBody(r)
+
+	var writer http.ResponseWriter = aw
+	if rp.capture != nil {
+		recorder := rp.capture.record(r, sessionHash, requestId)
+		writer = recorder.wrap(aw)
+		defer recorder.finish(aw, &entry)
+	}
 
 	start := time.Now()
-	targetHost := sessionmanager.MatchSessionContainer(sessionId, sessionHash)
+	session := sessionmanager.MatchSessionWithPriority(sessionId, sessionHash, priority)
+	targetHost := session.Addr
 	elapsed := time.Since(start)
 
 	cbroadcast.Broadcast(BProxyMetricTime, float64(elapsed.Microseconds())/1000.0)
 
+	entry.Instance = getInstanceId(targetHost)
+	entry.QueueMs = toMilliseconds(elapsed)
+
+	upstreamStart := time.Now()
+
 	// Create a new reverse proxy
 	proxy := &httputil.ReverseProxy{
 		Director: func(req *http.Request) {
@@ -36,20 +127,65 @@ func (rp *ReverseProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			req.URL.Host = targetHost
 			req.URL.Path = r.URL.Path
 			req.URL.RawQuery = r.URL.RawQuery
+
+			rp.headers.rewriteRequest(req, targetHost)
+			if rp.pow != nil {
+				rp.pow.strip(req)
+			}
+			req.Header.Set(requestIdHeader, requestId)
 		},
 
 		ModifyResponse: func(resp *http.Response) error {
-			log.Printf("[ReverseProxy] %s %s - %s http://%s%s %d %d", resp.Request.RemoteAddr, sessionHash, resp.Request.Method, targetHost, resp.Request.URL.Path, resp.StatusCode, resp.ContentLength)
+			entry.UpstreamMs = toMilliseconds(time.Since(upstreamStart))
+			rp.errorPage.success(targetHost)
+
+			rp.headers.rewriteResponse(resp, r.Host, targetHost)
+			rp.lifetime.apply(resp, session.EndsOn)
+			resp.Header.Set(requestIdHeader, requestId)
 			return nil
 		},
+
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			entry.UpstreamMs = toMilliseconds(time.Since(upstreamStart))
+			entry.Error = err.Error()
+
+			w.Header().Set(requestIdHeader, requestId)
+
+			//The player sent a body that is too large, the container is not at fault
+			if limitedBody != nil && limitedBody.isExceeded() {
+				rp.limits.reject(w, ThrottleBody)
+				return
+			}
+			rp.errorPage.serve(w, err, sessionHash, targetHost, requestId)
+		},
 	}
 
 	// Serve the request using the reverse proxy
-	proxy.ServeHTTP(w, r)
+	proxy.ServeHTTP(rp.limits.throttle(writer, r, sessionHash), r)
+}
+
+// rejectAuth answers the request when the session token is not accepted
+func rejectAuth(w http.ResponseWriter, err error) {
+	if errors.Is(err, auth.ErrUnavailable) {
+		w.Header().Set("Retry-After", "5")
+		http.Error(w, "The session could not be validated. Please try again later", http.StatusServiceUnavailable)
+		return
+	}
+	http.Error(w, "The session token is missing or invalid", http.StatusUnauthorized)
 }
 
 func (rp *ReverseProxy) Init() {
 	rp.sessionHeader = config.GetString(config.CReverseProxySessionHeader)
+	rp.headers = newHeaderPolicy(rp.sessionHeader)
+	rp.accessLog = newAccessLogger()
+	rp.capture = newHarCapture(rp.sessionHeader)
+	rp.errorPage = newErrorHandler()
+	rp.limits = newRateLimits()
+	rp.pow = newProofOfWork()
+	rp.auth = auth.New()
+	rp.lifetime = newLifetimeWarning()
+
+	log.Printf("[ReverseProxy] -> Rate limits | IP: %s | Session: %s | Bandwidth: %s", formatLimit(rp.limits.ip), formatLimit(rp.limits.session), formatLimit(rp.limits.bandwidth))
 }
 
 func (rp *ReverseProxy) Start() {
@@ -63,6 +199,7 @@ func (rp *ReverseProxy) Shutdown() {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	rp.h.Shutdown(ctx)
+	rp.accessLog.close()
 }
 
 func (rp *ReverseProxy) run() {
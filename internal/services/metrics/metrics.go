@@ -23,7 +23,9 @@ type MetricsService struct {
 	sessionStart cbroadcast.Channel
 	sessionStop  cbroadcast.Channel
 	sessionTime  cbroadcast.Channel
+	suspended    cbroadcast.Channel
 	httpRequest  cbroadcast.Channel
+	throttled    cbroadcast.Channel
 
 	metrics prometheusMetrics
 	data    dataMetrics
@@ -44,6 +46,7 @@ type prometheusMetrics struct {
 	containerRunning prometheus.Gauge
 	projectRunning   prometheus.Gauge
 	session          prometheus.Gauge
+	sessionSuspended prometheus.Gauge
 	httpRequestMax   prometheus.Gauge
 	sessionTimeMax   prometheus.Gauge
 
@@ -51,6 +54,8 @@ type prometheusMetrics struct {
 	sessionTime prometheus.Histogram
 
 	sessionServed prometheus.Counter
+
+	throttled *prometheus.CounterVec
 }
 
 func (m *MetricsService) Init() {
@@ -85,6 +90,12 @@ func (m *MetricsService) Init() {
 		Namespace: prometheusNamespace,
 	})
 
+	m.metrics.sessionSuspended = promauto.NewGauge(prometheus.GaugeOpts{
+		Name:      "sessions_suspended",
+		Help:      "Number of current sessions with suspended containers",
+		Namespace: prometheusNamespace,
+	})
+
 	m.metrics.httpRequestMax = promauto.NewGauge(prometheus.GaugeOpts{
 		Name:      "http_request_proxy_queue_time_max_milliseconds",
 		Help:      "Max time spent in queue waiting for a container to be available",
@@ -117,6 +128,12 @@ func (m *MetricsService) Init() {
 		Help:      "Number of total sessions served",
 		Namespace: prometheusNamespace,
 	})
+
+	m.metrics.throttled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name:      "http_request_throttled_total",
+		Help:      "Number of requests throttled by the rate limits",
+		Namespace: prometheusNamespace,
+	}, []string{"reason"})
 	m.subscribe()
 }
 
@@ -159,6 +176,9 @@ func (m *MetricsService) run() {
 		case <-m.sessionStop:
 			m.metrics.session.Dec()
 
+		case suspended := <-m.suspended:
+			m.metrics.sessionSuspended.Set(float64(suspended.(int)))
+
 		case elapsed := <-m.sessionTime:
 			elapsedS := elapsed.(int64)
 
@@ -178,6 +198,9 @@ func (m *MetricsService) run() {
 				m.data.httpRequestMax = elapsedMs
 				m.metrics.httpRequestMax.Set(m.data.httpRequestMax)
 			}
+
+		case reason := <-m.throttled:
+			m.metrics.throttled.WithLabelValues(reason.(string)).Inc()
 		}
 	}
 }
@@ -189,6 +212,8 @@ func (m *MetricsService) subscribe() {
 	m.sessionStart, _ = cbroadcast.Subscribe(sessionmanager.BSessionMetricStart)
 	m.sessionStop, _ = cbroadcast.Subscribe(sessionmanager.BSessionStop)
 	m.sessionTime, _ = cbroadcast.Subscribe(sessionmanager.BSessionMetricTime)
+	m.suspended, _ = cbroadcast.Subscribe(sessionmanager.BSessionMetricSuspended)
 
 	m.httpRequest, _ = cbroadcast.Subscribe(reverseproxy.BProxyMetricTime)
+	m.throttled, _ = cbroadcast.Subscribe(reverseproxy.BProxyMetricThrottled)
 }
@@ -2,21 +2,33 @@ package sessionmanager
 
 import "github.com/mart123p/ctf-reverseproxy/pkg/cbroadcast"
 
-const BSessionRequest = "session:request"          //Request a new container to be created
-const BSessionStop = "session:stop"                // Container addr that is no longer used by any session
-const BSessionMetricStart = "session:metric:start" // Sent when a new session is used
-const BSessionMetricTime = "session:metric:time"   // Elapsed time when a session closes
+const BSessionRequest = "session:request"                  //Request a new container to be created
+const BSessionStop = "session:stop"                        // Container addr that is no longer used by any session
+const BSessionMetricStart = "session:metric:start"         // Sent when a new session is used
+const BSessionMetricTime = "session:metric:time"           // Elapsed time when a session closes
+const BSessionSuspend = "session:suspend"                  // Container addr of an idle session that must be paused
+const BSessionResume = "session:resume"                    // Container addr of a suspended session that must be resumed
+const BSessionPool = "session:pool"                        // Number of containers ready in the pool when it changes
+const BSessionEvent = "session:event"                      // SessionEvent describing a change in the lifecycle of a session
+const BSessionMetricSuspended = "session:metric:suspended" // Number of suspended sessions
 
 const BSize = 5
+const BEventSize = 100 // Events are sent in bursts when an instance shared by many sessions fails
 
 func (d *SessionManagerService) Register() {
 	cbroadcast.Register(BSessionRequest, BSize)
 	cbroadcast.Register(BSessionStop, BSize)
 	cbroadcast.Register(BSessionMetricStart, BSize)
 	cbroadcast.Register(BSessionMetricTime, BSize)
+	cbroadcast.Register(BSessionSuspend, BSize)
+	cbroadcast.Register(BSessionResume, BSize)
+	cbroadcast.Register(BSessionMetricSuspended, BSize)
+	cbroadcast.Register(BSessionEvent, BEventSize)
+	cbroadcast.Register(BSessionPool, BSize)
 }
 
 // Extracted from internal/services/docker/broadcast.go to avoid circular dependency
 const bDockerReady = "docker:ready"
 const bDockerStop = "docker:stop"
 const bDockerState = "docker:state"
+const bDockerResumed = "docker:resumed"
@@ -0,0 +1,42 @@
+package sessionmanager
+
+import (
+	"time"
+
+	"github.com/mart123p/ctf-reverseproxy/pkg/cbroadcast"
+)
+
+// Types of the session events
+const EventAssigned = "assigned"   // A container is assigned to the session
+const EventExpired = "expired"     // The session reached its timeout or its maximum lifetime
+const EventDeleted = "deleted"     // The session is deleted with the management api
+const EventReset = "reset"         // The container of the session is restarted
+const EventFailed = "failed"       // The container of the session disappeared
+const EventSuspended = "suspended" // The containers of the idle session are suspended
+const EventResumed = "resumed"     // The containers of the session are running again
+
+// SessionEvent describes a change in the lifecycle of a session
+type SessionEvent struct {
+	Type        string
+	SessionHash string
+	SessionID   string
+	Team        string `json:",omitempty"`
+	Addr        string
+	Time        int64
+}
+
+// emit broadcasts the event of the session
+func emit(eventType string, sessionHash string, session *SessionState) {
+	event := SessionEvent{
+		Type:        eventType,
+		SessionHash: sessionHash,
+		SessionID:   session.SessionID,
+		Addr:        session.Addr,
+		Time:        time.Now().Unix(),
+	}
+	if team, ok := getTeam(session.SessionID); ok {
+		event.Team = team
+	}
+
+	cbroadcast.Broadcast(BSessionEvent, event)
+}
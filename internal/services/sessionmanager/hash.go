@@ -1,30 +1,214 @@
 package sessionmanager
 
 import (
+	"bytes"
+	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
-	"fmt"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"os"
 	"strings"
+	"sync"
 
 	"github.com/mart123p/ctf-reverseproxy/internal/config"
 )
 
-var salt string
+// Number of previous keys kept after a rotation
+const maxPreviousKeys = 3
 
+// KeyInfo identifies the keys with their fingerprint. The keys are never exposed
+type KeyInfo struct {
+	Active   string
+	Previous []string
+}
+
+// keyring holds the keys of the session hashes. GetHash is called by the reverse proxy outside of the run loop
+type keyring struct {
+	mu       sync.RWMutex
+	once     sync.Once
+	active   []byte
+	previous [][]byte //Most recent first
+}
+
+var keys keyring
+
+// loadKey reads the key from the salt file or from the config. The config can be set with an environment variable
+func loadKey() []byte {
+	if path := config.GetString(config.CReverseProxySessionSaltFile); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatalf("[SessionManager] -> Could not read the salt file \"%s\", %s", path, err)
+		}
+		return bytes.TrimSpace(data)
+	}
+	return []byte(config.GetString(config.CReverseProxySessionSalt))
+}
+
+// savedKeyring is the content of the keyring file. The keys are base64 encoded
+type savedKeyring struct {
+	Active   string
+	Previous []string
+}
+
+// loadKeyring reads the keys saved by the last rotation. Returns false when there is no keyring file
+func loadKeyring() ([]byte, [][]byte, bool) {
+	path := config.GetString(config.CReverseProxySessionKeyringFile)
+	if path == "" {
+		return nil, nil, false
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil, false
+	}
+	if err != nil {
+		log.Fatalf("[SessionManager] -> Could not read the keyring file \"%s\", %s", path, err)
+	}
+
+	var saved savedKeyring
+	if err := json.Unmarshal(data, &saved); err != nil {
+		log.Fatalf("[SessionManager] -> Could not parse the keyring file \"%s\", %s", path, err)
+	}
+	active, err := base64.StdEncoding.DecodeString(saved.Active)
+	if err != nil || len(active) == 0 {
+		log.Fatalf("[SessionManager] -> The active key of the keyring file \"%s\" is invalid", path)
+	}
+	previous := make([][]byte, 0, len(saved.Previous))
+	for _, value := range saved.Previous {
+		key, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			log.Fatalf("[SessionManager] -> A previous key of the keyring file \"%s\" is invalid", path)
+		}
+		previous = append(previous, key)
+	}
+	return active, previous, true
+}
+
+// saveKeyring writes the keys to the keyring file so a rotation survives a restart
+func saveKeyring(active []byte, previous [][]byte) error {
+	path := config.GetString(config.CReverseProxySessionKeyringFile)
+	if path == "" {
+		log.Printf("Warning: [SessionManager] -> The keyring file is not set. The rotated key is lost on restart")
+		return nil
+	}
+
+	saved := savedKeyring{
+		Active:   base64.StdEncoding.EncodeToString(active),
+		Previous: make([]string, 0, len(previous)),
+	}
+	for _, key := range previous {
+		saved.Previous = append(saved.Previous, base64.StdEncoding.EncodeToString(key))
+	}
+	data, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+
+	//Written to a temporary file first so a crash never leaves a partial keyring
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// load the keys. The keyring file saved by the last rotation takes precedence over the configured key
+func (k *keyring) load() {
+	k.once.Do(func() {
+		k.mu.Lock()
+		defer k.mu.Unlock()
+
+		if active, previous, ok := loadKeyring(); ok {
+			k.active = active
+			k.previous = previous
+			log.Printf("[SessionManager] -> Session keys loaded from the keyring file | Key: %s | Previous: %d", fingerprint(active), len(previous))
+			return
+		}
+		k.active = loadKey()
+	})
+}
+
+func (k *keyring) get() ([]byte, [][]byte) {
+	k.load()
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.active, k.previous
+}
+
+// rotate makes the key active. The current key is kept as a previous key
+func (k *keyring) rotate(key []byte) {
+	active, previous := k.get()
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.previous = append([][]byte{active}, previous...)
+	if len(k.previous) > maxPreviousKeys {
+		k.previous = k.previous[:maxPreviousKeys]
+	}
+	k.active = key
+
+	if err := saveKeyring(k.active, k.previous); err != nil {
+		log.Printf("Warning: [SessionManager] -> Could not save the keyring, the rotated key is lost on restart, %s", err)
+	}
+}
+
+func (k *keyring) info() KeyInfo {
+	active, previous := k.get()
+
+	info := KeyInfo{
+		Active:   fingerprint(active),
+		Previous: make([]string, 0, len(previous)),
+	}
+	for _, key := range previous {
+		info.Previous = append(info.Previous, fingerprint(key))
+	}
+	return info
+}
+
+func fingerprint(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:4])
+}
+
+// GetHash returns the HMAC-SHA256 of the session id with the active key
 func GetHash(sessionId string) string {
-	if salt == "" {
-		salt = config.GetString(config.CReverseProxySessionSalt)
+	active, _ := keys.get()
+	return hashWith(active, sessionId)
+}
+
+// GetHashes returns the hash of the session id with the active key followed by its hashes with the previous keys.
+// Used to find the captures, snapshots and audit entries saved before a rotation
+func GetHashes(sessionId string) []string {
+	return append([]string{GetHash(sessionId)}, getPreviousHashes(sessionId)...)
+}
+
+// getPreviousHashes returns the hashes of the session id with the previous keys
+func getPreviousHashes(sessionId string) []string {
+	_, previous := keys.get()
+
+	hashes := make([]string, 0, len(previous))
+	for _, key := range previous {
+		hashes = append(hashes, hashWith(key, sessionId))
 	}
+	return hashes
+}
 
-	var hash string
+func hashWith(key []byte, sessionId string) string {
 	if sessionId == "" {
-		hash = "none"
-	} else {
-		hashSalt := fmt.Sprintf("%s%s", sessionId, salt)
-		hashBytes := sha256.Sum256([]byte(hashSalt))
-
-		hash = base64.URLEncoding.EncodeToString(hashBytes[:])
-		hash = strings.ReplaceAll(hash, "=", "")
+		return "none"
 	}
-	return hash
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(sessionId))
+
+	hash := base64.URLEncoding.EncodeToString(mac.Sum(nil))
+	return strings.ReplaceAll(hash, "=", "")
+}
+
+// GetKeys returns the fingerprints of the active and previous keys
+func GetKeys() KeyInfo {
+	return keys.info()
 }
@@ -0,0 +1,82 @@
+package sessionmanager
+
+import (
+	"sort"
+	"sync"
+)
+
+// sessionIndex mirrors the hashes of the session map so a session can be checked without a round trip through the run loop
+type sessionIndex struct {
+	mu     sync.RWMutex
+	hashes map[string]bool
+}
+
+var index = sessionIndex{
+	hashes: make(map[string]bool),
+}
+
+func (i *sessionIndex) add(sessionHash string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.hashes[sessionHash] = true
+}
+
+func (i *sessionIndex) remove(sessionHash string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	delete(i.hashes, sessionHash)
+}
+
+// reset replaces the hashes after the sessions are moved to a new key
+func (i *sessionIndex) reset(sessionMaps ...map[string]*SessionState) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.hashes = make(map[string]bool)
+	for _, sessionMap := range sessionMaps {
+		for sessionHash := range sessionMap {
+			i.hashes[sessionHash] = true
+		}
+	}
+}
+
+func (i *sessionIndex) has(sessionHash string) bool {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return i.hashes[sessionHash]
+}
+
+// instanceUsage records the sessions assigned to each instance until the docker service removes it
+type instanceUsage struct {
+	mu       sync.Mutex
+	sessions map[string]map[string]bool //Container addr -> session ids
+}
+
+var usage = instanceUsage{
+	sessions: make(map[string]map[string]bool),
+}
+
+func (u *instanceUsage) add(addr string, sessionID string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if _, ok := u.sessions[addr]; !ok {
+		u.sessions[addr] = make(map[string]bool)
+	}
+	u.sessions[addr][sessionID] = true
+}
+
+// TakeInstanceSessions returns the hashes of the sessions that used the instance and forgets it. The hashes are computed with the active key
+func TakeInstanceSessions(addr string) []string {
+	usage.mu.Lock()
+	sessions := usage.sessions[addr]
+	delete(usage.sessions, addr)
+	usage.mu.Unlock()
+
+	hashes := make([]string, 0, len(sessions))
+	for sessionID := range sessions {
+		hashes = append(hashes, GetHash(sessionID))
+	}
+	sort.Strings(hashes)
+	return hashes
+}
@@ -0,0 +1,163 @@
+package sessionmanager
+
+import (
+	"log"
+
+	"github.com/mart123p/ctf-reverseproxy/pkg/cbroadcast"
+)
+
+// PoolState describes the containers that are not assigned to a session
+type PoolState struct {
+	Size   int            //Number of containers kept ready
+	Ready  []string       //Containers ready to be used
+	Queue  int            //Requests waiting for a container
+	Shared map[string]int `json:",omitempty"` //Sessions per shared instance
+}
+
+// Status of an instance
+const (
+	InstancePooled    = "pooled"    //Ready to be assigned
+	InstanceShared    = "shared"    //Shared by many sessions
+	InstanceReserved  = "reserved"  //Kept warm for a reservation
+	InstanceAssigned  = "assigned"  //Assigned to a session
+	InstanceSuspended = "suspended" //Assigned to a session. The containers are paused or stopped
+	InstanceRemoving  = "removing"  //Being torn down
+)
+
+// InstanceStatus describes the use of an instance
+type InstanceStatus struct {
+	Status   string
+	Sessions []string //Hashes of the sessions using the instance
+}
+
+// resizePool changes the number of containers kept ready. The extra containers are stopped
+func (s *SessionManagerService) resizePool(size int) {
+	log.Printf("[SessionManager] -> Pool resized | Previous: %d | Size: %d", s.poolTarget, size)
+	s.poolTarget = size
+
+	if s.shared {
+		s.scaleShared()
+		return
+	}
+
+	//The pool is filled once the state of docker is known
+	if !s.started {
+		return
+	}
+
+	required := size - (len(s.containerPoolQueue) + len(s.requestQueue))
+	for i := 0; i < required; i++ {
+		cbroadcast.Broadcast(BSessionRequest, nil)
+	}
+
+	for len(s.containerPoolQueue) > size {
+		container := s.containerPoolQueue[len(s.containerPoolQueue)-1]
+		s.containerPoolQueue = s.containerPoolQueue[:len(s.containerPoolQueue)-1]
+
+		cbroadcast.Broadcast(BSessionStop, container)
+		s.containerRemovedMap[container] = getExpiresOnMinute()
+	}
+}
+
+func (s *SessionManagerService) getPool() PoolState {
+	state := PoolState{
+		Size:  s.poolTarget,
+		Ready: append([]string{}, s.containerPoolQueue...),
+		Queue: len(s.requestQueue),
+	}
+
+	if s.shared {
+		state.Ready = append([]string{}, s.sharedPool.instances...)
+		state.Shared = make(map[string]int, len(s.sharedPool.sessions))
+		for addr, sessions := range s.sharedPool.sessions {
+			state.Shared[addr] = sessions
+		}
+	}
+	return state
+}
+
+// removeFromPool removes the container from the ready containers. Returns false if it is not in the pool
+func (s *SessionManagerService) removeFromPool(addr string) bool {
+	for i, container := range s.containerPoolQueue {
+		if container == addr {
+			s.containerPoolQueue = append(s.containerPoolQueue[:i], s.containerPoolQueue[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// recycleInstance stops the instance at addr. Its sessions get a new instance on their next request and the pool is refilled
+func (s *SessionManagerService) recycleInstance(addr string) bool {
+	if _, ok := s.containerRemovedMap[addr]; ok {
+		return false
+	}
+	log.Printf("[SessionManager] -> Recycling instance | Container Addr: %s", addr)
+
+	if s.shared {
+		if !s.sharedPool.has(addr) {
+			return false
+		}
+		s.stopSharedInstance(addr, EventReset)
+		s.scaleShared()
+		return true
+	}
+
+	if sessionHash, ok := s.containerMap[addr]; ok {
+		s.recycleSession(sessionHash, s.sessionMap[sessionHash])
+		return true
+	}
+
+	if reservation, ok := s.isReserved(addr); ok {
+		reservation.Addr = ""
+		reservation.requestContainer()
+	} else if !s.removeFromPool(addr) {
+		return false
+	}
+
+	//The pool is refilled once the docker service reports the container as stopped
+	cbroadcast.Broadcast(BSessionStop, addr)
+	s.containerRemovedMap[addr] = getExpiresOnMinute()
+	return true
+}
+
+// getInstanceStatus returns the status of every instance known by the session manager
+func (s *SessionManagerService) getInstanceStatus() map[string]InstanceStatus {
+	result := make(map[string]InstanceStatus)
+	add := func(addr string, status string, sessionHash string) {
+		instance, ok := result[addr]
+		if !ok {
+			instance = InstanceStatus{Status: status, Sessions: make([]string, 0)}
+		}
+		if sessionHash != "" {
+			instance.Sessions = append(instance.Sessions, sessionHash)
+		}
+		result[addr] = instance
+	}
+
+	for _, addr := range s.containerPoolQueue {
+		add(addr, InstancePooled, "")
+	}
+	for _, addr := range s.sharedPool.instances {
+		add(addr, InstanceShared, "")
+	}
+	for _, reservation := range s.reservations {
+		if reservation.Addr != "" {
+			add(reservation.Addr, InstanceReserved, "")
+		}
+	}
+	for sessionHash, session := range s.sessionMap {
+		status := InstanceAssigned
+		if session.Suspended {
+			status = InstanceSuspended
+		}
+		if s.shared {
+			status = InstanceShared
+		}
+		add(session.Addr, status, sessionHash)
+	}
+	for addr := range s.containerRemovedMap {
+		result[addr] = InstanceStatus{Status: InstanceRemoving, Sessions: make([]string, 0)}
+	}
+	return result
+}
@@ -0,0 +1,126 @@
+package sessionmanager
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mart123p/ctf-reverseproxy/internal/config"
+)
+
+// QueueEntry describes a request waiting for a container
+type QueueEntry struct {
+	Position    int
+	SessionHash string
+	Priority    int
+	Score       float64 //Priority including the time waited in the queue
+	WaitMs      int64
+}
+
+// priorityRegistry keeps the priorities assigned with the management api
+type priorityRegistry struct {
+	mu     sync.RWMutex
+	values map[string]int //Session id -> priority
+}
+
+var priorities = priorityRegistry{
+	values: make(map[string]int),
+}
+
+// SetPriority assigns the queue priority of the session id. Higher is served first
+func SetPriority(sessionID string, priority int) {
+	priorities.mu.Lock()
+	priorities.values[sessionID] = priority
+	priorities.mu.Unlock()
+}
+
+// DeletePriority removes the priority assigned to the session id. Returns false if no priority is assigned
+func DeletePriority(sessionID string) bool {
+	priorities.mu.Lock()
+	defer priorities.mu.Unlock()
+
+	_, ok := priorities.values[sessionID]
+	delete(priorities.values, sessionID)
+	return ok
+}
+
+// GetPriorities returns the priorities assigned with the management api
+func GetPriorities() map[string]int {
+	priorities.mu.RLock()
+	defer priorities.mu.RUnlock()
+
+	result := make(map[string]int, len(priorities.values))
+	for sessionID, priority := range priorities.values {
+		result[sessionID] = priority
+	}
+	return result
+}
+
+// getPriority returns the highest of the priority of the request and the one assigned to the session id
+func getPriority(sessionID string, priority int) int {
+	priorities.mu.RLock()
+	defer priorities.mu.RUnlock()
+
+	if assigned, ok := priorities.values[sessionID]; ok && assigned > priority {
+		return assigned
+	}
+	return priority
+}
+
+// score returns the priority of the request. Requests gain one level for every aging period spent in the queue so low priorities still progress
+func (m *matchRequest) score(now time.Time) float64 {
+	score := float64(m.priority)
+
+	aging := config.GetInt64(config.CReverseProxyQueueAging)
+	if aging > 0 {
+		score += now.Sub(m.queuedOn).Seconds() / float64(aging)
+	}
+	return score
+}
+
+// enqueue adds a request waiting for a container
+func (s *SessionManagerService) enqueue(matchRequest *matchRequest) {
+	matchRequest.queuedOn = time.Now()
+	s.requestQueue = append(s.requestQueue, matchRequest)
+}
+
+// dequeue removes the request with the highest score. Requests with the same score are served in order of arrival
+func (s *SessionManagerService) dequeue() *matchRequest {
+	now := time.Now()
+
+	selected := 0
+	best := s.requestQueue[0].score(now)
+	for i, matchRequest := range s.requestQueue[1:] {
+		if score := matchRequest.score(now); score > best {
+			selected = i + 1
+			best = score
+		}
+	}
+
+	matchRequest := s.requestQueue[selected]
+	s.requestQueue = append(s.requestQueue[:selected], s.requestQueue[selected+1:]...)
+	return matchRequest
+}
+
+// getQueue returns the waiting requests in the order they are served
+func (s *SessionManagerService) getQueue() []QueueEntry {
+	now := time.Now()
+
+	entries := make([]QueueEntry, 0, len(s.requestQueue))
+	for _, matchRequest := range s.requestQueue {
+		entries = append(entries, QueueEntry{
+			SessionHash: matchRequest.sessionHash,
+			Priority:    matchRequest.priority,
+			Score:       matchRequest.score(now),
+			WaitMs:      now.Sub(matchRequest.queuedOn).Milliseconds(),
+		})
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Score > entries[j].Score
+	})
+	for i := range entries {
+		entries[i].Position = i + 1
+	}
+	return entries
+}
@@ -1,9 +1,18 @@
 package sessionmanager
 
+import (
+	"time"
+
+	"github.com/mart123p/ctf-reverseproxy/internal/config"
+)
+
 type matchRequest struct {
 	sessionID    string
 	sessionHash  string
-	responseChan chan string //Channel to send the container url
+	options      *SessionOptions   //Options applied to the session. Can be nil
+	priority     int               //Priority in the queue when no container is available
+	queuedOn     time.Time         //Time when the request was added to the queue
+	responseChan chan SessionState //Channel to send the session with the container url
 }
 
 type deleteRequest struct {
@@ -11,6 +20,49 @@ type deleteRequest struct {
 	responseChan chan bool
 }
 
+type recycleRequest struct {
+	sessionHash  string
+	addr         string
+	responseChan chan bool
+}
+
+type extendRequest struct {
+	sessionHash  string
+	seconds      int64
+	responseChan chan *SessionState
+}
+
+type updateRequest struct {
+	sessionHash  string
+	options      *SessionOptions
+	responseChan chan *SessionState
+}
+
+type reserveRequest struct {
+	sessionIDs   []string
+	responseChan chan []Reservation
+}
+
+type releaseRequest struct {
+	sessionHash  string
+	responseChan chan bool
+}
+
+type rotateRequest struct {
+	key          []byte //New active key. A random key is generated when empty
+	responseChan chan KeyInfo
+}
+
+type resizeRequest struct {
+	size         int
+	responseChan chan PoolState
+}
+
+type recycleInstanceRequest struct {
+	addr         string
+	responseChan chan bool
+}
+
 var singleton *SessionManagerService
 
 func GetSessions() map[string]SessionState {
@@ -21,11 +73,32 @@ func GetSessions() map[string]SessionState {
 
 // MatchSessionContainer returns the url of the container that is matched to the sessionHash
 func MatchSessionContainer(sessionID string, sessionHash string) string {
+	return MatchSession(sessionID, sessionHash).Addr
+}
+
+// MatchSession returns the state of the session once a container is matched to the sessionHash
+func MatchSession(sessionID string, sessionHash string) SessionState {
+	return MatchSessionWithOptions(sessionID, sessionHash, nil)
+}
+
+// MatchSessionWithOptions returns the state of the session once a container is matched. The options are applied to the session
+func MatchSessionWithOptions(sessionID string, sessionHash string, options *SessionOptions) SessionState {
+	return matchSession(sessionID, sessionHash, options, 0)
+}
+
+// MatchSessionWithPriority returns the state of the session once a container is matched. The priority is used when the request waits in the queue
+func MatchSessionWithPriority(sessionID string, sessionHash string, priority int) SessionState {
+	return matchSession(sessionID, sessionHash, nil, priority)
+}
+
+func matchSession(sessionID string, sessionHash string, options *SessionOptions, priority int) SessionState {
 	//Create a match request
 	match := matchRequest{
 		sessionID:    sessionID,
 		sessionHash:  sessionHash,
-		responseChan: make(chan string),
+		options:      options,
+		priority:     getPriority(sessionID, priority),
+		responseChan: make(chan SessionState),
 	}
 
 	//Send the match request
@@ -48,3 +121,142 @@ func DeleteSession(sessionHash string) bool {
 	//Wait for the response
 	return <-delete.responseChan
 }
+
+// RecycleSession stops the container at addr assigned to the sessionHash. The next request of the session is assigned a new container
+func RecycleSession(sessionHash string, addr string) bool {
+	//Create a recycle request
+	recycle := recycleRequest{
+		sessionHash:  sessionHash,
+		addr:         addr,
+		responseChan: make(chan bool),
+	}
+
+	//Send the recycle request
+	singleton.RecycleChan <- recycle
+
+	//Wait for the response
+	return <-recycle.responseChan
+}
+
+// SessionExists returns true if a container is already assigned to the sessionHash computed with the active key, or if its container was recycled. Does not go through the run loop
+func SessionExists(sessionHash string) bool {
+	return index.has(sessionHash)
+}
+
+// ExtendSession pushes back the maximum lifetime of the session. Returns nil if the session is not found
+func ExtendSession(sessionHash string, seconds int64) *SessionState {
+	extend := extendRequest{
+		sessionHash:  sessionHash,
+		seconds:      seconds,
+		responseChan: make(chan *SessionState),
+	}
+
+	singleton.ExtendChan <- extend
+
+	return <-extend.responseChan
+}
+
+// UpdateSession applies the options to the session. Returns nil if the session is not found
+func UpdateSession(sessionHash string, options *SessionOptions) *SessionState {
+	update := updateRequest{
+		sessionHash:  sessionHash,
+		options:      options,
+		responseChan: make(chan *SessionState),
+	}
+
+	singleton.UpdateChan <- update
+
+	return <-update.responseChan
+}
+
+// ReserveSessions keeps a warm container for each of the session ids. The session ids must be resolved
+func ReserveSessions(sessionIDs []string) ([]Reservation, error) {
+	if config.GetBool(config.CReverseProxySharedEnabled) {
+		return nil, ErrReservationShared
+	}
+
+	reserve := reserveRequest{
+		sessionIDs:   sessionIDs,
+		responseChan: make(chan []Reservation),
+	}
+
+	singleton.ReserveChan <- reserve
+
+	return <-reserve.responseChan, nil
+}
+
+// ReleaseSession removes the reservation of the sessionHash. Returns false if the session has no reservation
+func ReleaseSession(sessionHash string) bool {
+	release := releaseRequest{
+		sessionHash:  sessionHash,
+		responseChan: make(chan bool),
+	}
+
+	singleton.ReleaseChan <- release
+
+	return <-release.responseChan
+}
+
+// GetReservations returns the reservations sorted by session id
+func GetReservations() []Reservation {
+	responseChan := make(chan []Reservation)
+	singleton.GetReservationsChan <- responseChan
+	return <-responseChan
+}
+
+// GetQueue returns the requests waiting for a container in the order they are served
+func GetQueue() []QueueEntry {
+	responseChan := make(chan []QueueEntry)
+	singleton.GetQueueChan <- responseChan
+	return <-responseChan
+}
+
+// RotateKey makes the key active for the session hashes. The sessions keep their container
+func RotateKey(key []byte) KeyInfo {
+	rotate := rotateRequest{
+		key:          key,
+		responseChan: make(chan KeyInfo),
+	}
+
+	singleton.RotateChan <- rotate
+
+	return <-rotate.responseChan
+}
+
+// ResizePool changes the number of containers kept ready. In the shared mode it is the minimum number of instances
+func ResizePool(size int) PoolState {
+	resize := resizeRequest{
+		size:         size,
+		responseChan: make(chan PoolState),
+	}
+
+	singleton.ResizeChan <- resize
+
+	return <-resize.responseChan
+}
+
+// GetPool returns the state of the containers that are not assigned to a session
+func GetPool() PoolState {
+	responseChan := make(chan PoolState)
+	singleton.GetPoolChan <- responseChan
+	return <-responseChan
+}
+
+// RecycleInstance stops the instance at addr whether it is pooled, reserved or assigned. Returns false if the instance is unknown or already removed
+func RecycleInstance(addr string) bool {
+	recycle := recycleInstanceRequest{
+		addr:         addr,
+		responseChan: make(chan bool),
+	}
+
+	singleton.RecycleInstanceChan <- recycle
+
+	return <-recycle.responseChan
+}
+
+// GetInstanceStatus returns the status of the instances by container addr
+func GetInstanceStatus() map[string]InstanceStatus {
+	responseChan := make(chan map[string]InstanceStatus)
+	singleton.GetInstancesChan <- responseChan
+	return <-responseChan
+}
@@ -0,0 +1,193 @@
+package sessionmanager
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/mart123p/ctf-reverseproxy/internal/config"
+	"github.com/mart123p/ctf-reverseproxy/pkg/cbroadcast"
+)
+
+var ErrReservationShared = errors.New("reservations are not supported with shared instances")
+
+// Reservation of a warm instance for a session. The instance is never given to another session
+type Reservation struct {
+	SessionID string
+	Addr      string `json:",omitempty"` //Warm container. Empty while the container is starting or once the session uses it
+	InUse     bool   //The session is active
+
+	pending bool //A container is starting for the reservation
+}
+
+// requestContainer requests a warm container for the reservation
+func (reservation *Reservation) requestContainer() {
+	reservation.pending = true
+	cbroadcast.Broadcast(BSessionRequest, nil)
+}
+
+// loadReservations reads the reservations saved in the reservations file. The containers are requested once the state of docker is known
+func (s *SessionManagerService) loadReservations() {
+	path := config.GetString(config.CReverseProxySessionReservationsFile)
+	if path == "" {
+		return
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("[SessionManager] -> Could not read the reservations file \"%s\", %s", path, err)
+	}
+
+	var sessionIDs []string
+	if err := json.Unmarshal(data, &sessionIDs); err != nil {
+		log.Fatalf("[SessionManager] -> Could not parse the reservations file \"%s\", %s", path, err)
+	}
+
+	for _, sessionID := range sessionIDs {
+		s.reservations[GetHash(sessionID)] = &Reservation{SessionID: sessionID}
+	}
+	log.Printf("[SessionManager] -> %d reservations loaded from \"%s\"", len(sessionIDs), path)
+}
+
+// saveReservations writes the session ids of the reservations in the reservations file
+func (s *SessionManagerService) saveReservations() {
+	path := config.GetString(config.CReverseProxySessionReservationsFile)
+	if path == "" {
+		return
+	}
+
+	sessionIDs := make([]string, 0, len(s.reservations))
+	for _, reservation := range s.reservations {
+		sessionIDs = append(sessionIDs, reservation.SessionID)
+	}
+	sort.Strings(sessionIDs)
+
+	data, _ := json.Marshal(sessionIDs)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		log.Printf("Warning: [SessionManager] -> Could not save the reservations in \"%s\", %s", path, err)
+	}
+}
+
+// reserve adds a reservation for the session and requests its container
+func (s *SessionManagerService) reserve(sessionID string) {
+	sessionHash := GetHash(sessionID)
+	if _, ok := s.reservations[sessionHash]; ok {
+		return
+	}
+
+	reservation := &Reservation{SessionID: sessionID}
+	s.reservations[sessionHash] = reservation
+
+	//The container is requested once the session ends
+	if _, ok := s.sessionMap[sessionHash]; ok {
+		reservation.InUse = true
+	} else if s.started {
+		reservation.requestContainer()
+	}
+
+	log.Printf("[SessionManager] -> Instance reserved | Session: %s", sessionHash)
+}
+
+// release removes the reservation. The warm container is stopped
+func (s *SessionManagerService) release(sessionHash string) bool {
+	reservation, ok := s.reservations[sessionHash]
+	if !ok {
+		return false
+	}
+	delete(s.reservations, sessionHash)
+
+	if reservation.Addr != "" {
+		cbroadcast.Broadcast(BSessionStop, reservation.Addr)
+		s.containerRemovedMap[reservation.Addr] = getExpiresOnMinute()
+	}
+
+	log.Printf("[SessionManager] -> Reservation released | Session: %s", sessionHash)
+	return true
+}
+
+// matchReserved assigns the warm container of the reservation to the session. Returns false if the session has no warm container
+func (s *SessionManagerService) matchReserved(matchRequest *matchRequest) bool {
+	reservation, ok := s.reservations[matchRequest.sessionHash]
+	if !ok {
+		return false
+	}
+	reservation.InUse = true
+
+	//The reserved container is still starting. The session uses the pool and the container is kept for its next session
+	if reservation.Addr == "" {
+		return false
+	}
+
+	container := reservation.Addr
+	reservation.Addr = ""
+
+	s.containerMap[container] = matchRequest.sessionHash
+	session := s.newSession(matchRequest, container)
+	s.addSession(matchRequest.sessionHash, session)
+	emit(EventAssigned, matchRequest.sessionHash, session)
+
+	log.Printf("[SessionManager] -> Reserved container assigned to session | Session: %s | Container Addr: %s", matchRequest.sessionHash, container)
+
+	cbroadcast.Broadcast(BSessionMetricStart, nil)
+	matchRequest.responseChan <- *session
+	return true
+}
+
+// fillReservation gives the ready container to a reservation waiting for one. Returns false if no reservation is waiting.
+// The container is kept reserved even when the session started on a pool container in the meantime
+func (s *SessionManagerService) fillReservation(addr string) bool {
+	for sessionHash, reservation := range s.reservations {
+		if reservation.pending {
+			reservation.pending = false
+			reservation.Addr = addr
+			log.Printf("[SessionManager] -> Reserved container ready | Session: %s | Container Addr: %s", sessionHash, addr)
+			return true
+		}
+	}
+	return false
+}
+
+// endReservedSession requests a new warm container once the session of a reservation ends. Unless its container is ready or starting
+func (s *SessionManagerService) endReservedSession(sessionHash string) {
+	if reservation, ok := s.reservations[sessionHash]; ok && reservation.InUse {
+		reservation.InUse = false
+		if reservation.Addr == "" && !reservation.pending {
+			reservation.requestContainer()
+		}
+	}
+}
+
+// isReserved returns the reservation holding the warm container at addr
+func (s *SessionManagerService) isReserved(addr string) (*Reservation, bool) {
+	for _, reservation := range s.reservations {
+		if reservation.Addr == addr {
+			return reservation, true
+		}
+	}
+	return nil, false
+}
+
+// requestReservations requests the containers of the reservations that are waiting for one
+func (s *SessionManagerService) requestReservations() {
+	for _, reservation := range s.reservations {
+		if reservation.Addr == "" && !reservation.InUse && !reservation.pending {
+			reservation.requestContainer()
+		}
+	}
+}
+
+func (s *SessionManagerService) getReservations() []Reservation {
+	reservations := make([]Reservation, 0, len(s.reservations))
+	for _, reservation := range s.reservations {
+		reservations = append(reservations, *reservation)
+	}
+	sort.Slice(reservations, func(i, j int) bool {
+		return reservations[i].SessionID < reservations[j].SessionID
+	})
+	return reservations
+}
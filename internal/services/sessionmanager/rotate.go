@@ -0,0 +1,86 @@
+package sessionmanager
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+)
+
+// newKey returns a random key used when the rotation does not provide one
+func newKey() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return []byte(hex.EncodeToString(key))
+}
+
+// rotateKey makes the key active and moves the sessions to the hashes of the new key
+func (s *SessionManagerService) rotateKey(key []byte) {
+	keys.rotate(key)
+
+	aliases := make(map[string]string)
+	rekey := func(sessionID string, previousHash string) string {
+		hash := GetHash(sessionID)
+		for _, alias := range getPreviousHashes(sessionID) {
+			aliases[alias] = hash
+		}
+		aliases[previousHash] = hash
+		return hash
+	}
+
+	sessionMap := make(map[string]*SessionState, len(s.sessionMap))
+	for previousHash, session := range s.sessionMap {
+		hash := rekey(session.SessionID, previousHash)
+		sessionMap[hash] = session
+	}
+	s.sessionMap = sessionMap
+
+	recycled := make(map[string]*SessionState, len(s.recycled))
+	for previousHash, session := range s.recycled {
+		recycled[rekey(session.SessionID, previousHash)] = session
+	}
+	s.recycled = recycled
+	index.reset(sessionMap, recycled)
+
+	for addr, previousHash := range s.containerMap {
+		if hash, ok := aliases[previousHash]; ok {
+			s.containerMap[addr] = hash
+		}
+	}
+
+	resumeQueue := make(map[string][]*matchRequest, len(s.resumeQueue))
+	for previousHash, waiting := range s.resumeQueue {
+		if hash, ok := aliases[previousHash]; ok {
+			previousHash = hash
+		}
+		resumeQueue[previousHash] = waiting
+	}
+	s.resumeQueue = resumeQueue
+
+	for _, matchRequest := range s.requestQueue {
+		matchRequest.sessionHash = GetHash(matchRequest.sessionID)
+	}
+
+	reservations := make(map[string]*Reservation, len(s.reservations))
+	for previousHash, reservation := range s.reservations {
+		hash := rekey(reservation.SessionID, previousHash)
+		reservations[hash] = reservation
+	}
+	s.reservations = reservations
+
+	s.aliases = aliases
+
+	log.Printf("[SessionManager] -> Session key rotated | Key: %s | Sessions: %d", fingerprint(key), len(s.sessionMap))
+}
+
+// lookupHash returns the hash of the session when the hash was computed with a previous key
+func (s *SessionManagerService) lookupHash(sessionHash string) string {
+	if _, ok := s.sessionMap[sessionHash]; ok {
+		return sessionHash
+	}
+	if hash, ok := s.aliases[sessionHash]; ok {
+		return hash
+	}
+	return sessionHash
+}
@@ -10,26 +10,62 @@ import (
 )
 
 type SessionState struct {
-	SessionID string
-	Addr      string
-	ExpiresOn int64
-	StartedOn int64
+	SessionID  string
+	Addr       string
+	ExpiresOn  int64
+	StartedOn  int64
+	EndsOn     int64 `json:",omitempty"` //Hard limit of the session lifetime
+	LastSeenOn int64
+	Suspended  bool     `json:",omitempty"` //The containers are paused until the next request
+	Team       string   `json:",omitempty"`
+	Members    []string `json:",omitempty"` //Hashes of the known members of the team
+
+	Timeout     int64            `json:",omitempty"` //Idle timeout override in seconds
+	MaxLifetime int64            `json:",omitempty"` //Maximum lifetime override in seconds
+	Metadata    *SessionMetadata `json:",omitempty"`
 }
 
 type SessionManagerService struct {
 	shutdown        chan bool
 	MatchChan       chan matchRequest
-	DeleteChan      chan deleteRequest // Remove a session
+	DeleteChan      chan deleteRequest  // Remove a session
+	RecycleChan     chan recycleRequest // Stop the container of a session that is unreachable
+	ExtendChan      chan extendRequest  // Extend the maximum lifetime of a session
+	UpdateChan      chan updateRequest  // Update the timeouts and the metadata of a session
 	GetSessionsChan chan chan map[string]SessionState
 
-	dockerReady cbroadcast.Channel
-	dockerStop  cbroadcast.Channel
-	dockerState cbroadcast.Channel
+	ReserveChan         chan reserveRequest // Reserve warm containers for sessions
+	ReleaseChan         chan releaseRequest // Release the reservation of a session
+	GetReservationsChan chan chan []Reservation
+	GetQueueChan        chan chan []QueueEntry
+	RotateChan          chan rotateRequest // Rotate the key of the session hashes
+	ResizeChan          chan resizeRequest // Change the number of containers kept ready
+	GetPoolChan         chan chan PoolState
+	RecycleInstanceChan chan recycleInstanceRequest // Stop an instance whatever its use
+	GetInstancesChan    chan chan map[string]InstanceStatus
 
-	containerPoolQueue []string        //Queue used to keep track of the pool of containers that are ready to be used
-	requestQueue       []*matchRequest //Queue used to keep track of the requests that are waiting for a container to be ready
+	dockerReady   cbroadcast.Channel
+	dockerStop    cbroadcast.Channel
+	dockerState   cbroadcast.Channel
+	dockerResumed cbroadcast.Channel
 
-	started bool
+	containerPoolQueue []string                   //Queue used to keep track of the pool of containers that are ready to be used
+	requestQueue       []*matchRequest            //Queue used to keep track of the requests that are waiting for a container to be ready
+	resumeQueue        map[string][]*matchRequest //Requests waiting for the container of a suspended session
+	resuming           map[string]time.Time       //Container addr -> time the resume of the container was first asked
+
+	started    bool
+	poolSize   int //Last pool size broadcasted
+	poolTarget int //Number of containers kept ready. Can be changed with the management api
+
+	shared     bool       //Sessions are mapped onto shared instances
+	sharedPool sharedPool //Instances used in the shared mode
+
+	reservations map[string]*Reservation //Warm containers kept outside the pool for specific sessions
+
+	aliases map[string]string //Hash computed with a previous key -> hash of the session with the active key
+
+	recycled map[string]*SessionState //Sessions whose container was recycled. Their state is given back on their next container
 
 	sessionMap          map[string]*SessionState
 	containerMap        map[string]string //Map used to keep track of the containers that are assigned to a session
@@ -37,20 +73,45 @@ type SessionManagerService struct {
 }
 
 func (s *SessionManagerService) Init() {
+	//The keys saved by the last rotation keep the hashes of the sessions after a restart
+	keys.load()
+
 	s.shutdown = make(chan bool)
 
 	s.MatchChan = make(chan matchRequest)
 	s.DeleteChan = make(chan deleteRequest)
+	s.RecycleChan = make(chan recycleRequest)
+	s.ExtendChan = make(chan extendRequest)
+	s.UpdateChan = make(chan updateRequest)
 	s.GetSessionsChan = make(chan chan map[string]SessionState)
+	s.ReserveChan = make(chan reserveRequest)
+	s.ReleaseChan = make(chan releaseRequest)
+	s.GetReservationsChan = make(chan chan []Reservation)
+	s.GetQueueChan = make(chan chan []QueueEntry)
+	s.RotateChan = make(chan rotateRequest)
+	s.ResizeChan = make(chan resizeRequest)
+	s.GetPoolChan = make(chan chan PoolState)
+	s.RecycleInstanceChan = make(chan recycleInstanceRequest)
+	s.GetInstancesChan = make(chan chan map[string]InstanceStatus)
 
 	s.sessionMap = make(map[string]*SessionState)
 	s.containerMap = make(map[string]string)
 	s.containerRemovedMap = make(map[string]int64)
 	s.containerPoolQueue = make([]string, 0)
 	s.requestQueue = make([]*matchRequest, 0)
+	s.resumeQueue = make(map[string][]*matchRequest)
+	s.resuming = make(map[string]time.Time)
 	s.started = false
+	s.poolTarget = config.GetInt(config.CReverseProxyPool)
+	s.shared = config.GetBool(config.CReverseProxySharedEnabled)
+	s.sharedPool = newSharedPool()
+	s.reservations = make(map[string]*Reservation)
+	s.aliases = make(map[string]string)
+	s.recycled = make(map[string]*SessionState)
 
 	s.subscribe()
+	loadTeams()
+	s.loadReservations()
 
 	singleton = s
 }
@@ -80,63 +141,136 @@ func (s *SessionManagerService) run() {
 		case matchRequest := <-s.MatchChan:
 			log.Printf("[SessionManager] -> Match request received | Session: %s", matchRequest.sessionHash)
 
-			if session, ok := s.sessionMap[matchRequest.sessionHash]; ok {
-				session.ExpiresOn = getExpiresOn()
-				matchRequest.responseChan <- session.Addr
+			//The hash could have been computed with the key before a rotation
+			matchRequest.sessionHash = GetHash(matchRequest.sessionID)
+			s.match(&matchRequest)
+
+		case deleteRequest := <-s.DeleteChan:
+			sessionHash := s.lookupHash(deleteRequest.sessionHash)
+
+			found := false
+			//Check if there is a session assigned to the container
+			if session, ok := s.sessionMap[sessionHash]; ok {
+				// Remove the container from the maps
+				s.removeSession(sessionHash, session.Addr)
+				emit(EventDeleted, sessionHash, session)
+				found = true
+			} else if session, ok := s.recycled[sessionHash]; ok {
+				s.forgetRecycled(sessionHash)
+				emit(EventDeleted, sessionHash, session)
+				found = true
+			} else {
+				log.Printf("[SessionManager] -> Session not found | Session: %s", sessionHash)
+			}
+
+			deleteRequest.responseChan <- found
+
+		case recycleRequest := <-s.RecycleChan:
+			recycleRequest.sessionHash = s.lookupHash(recycleRequest.sessionHash)
+			recycled := false
+			//The session could already be using another container
+			if session, ok := s.sessionMap[recycleRequest.sessionHash]; ok && session.Addr == recycleRequest.addr {
+				log.Printf("[SessionManager] -> Recycling unreachable container | Session: %s | Container Addr: %s", recycleRequest.sessionHash, session.Addr)
+				if s.shared {
+					//Every session of the instance gets another one
+					s.stopSharedInstance(session.Addr, EventReset)
+					s.scaleShared()
+				} else {
+					s.recycleSession(recycleRequest.sessionHash, session)
+				}
+				recycled = true
+			}
+
+			recycleRequest.responseChan <- recycled
+
+		case extendRequest := <-s.ExtendChan:
+			extendRequest.sessionHash = s.lookupHash(extendRequest.sessionHash)
+			session, ok := s.sessionMap[extendRequest.sessionHash]
+			if !ok {
+				extendRequest.responseChan <- nil
 				continue
 			}
 
-			//Request a new container
-			cbroadcast.Broadcast(BSessionRequest, nil)
-			cbroadcast.Broadcast(BSessionMetricStart, nil)
+			//Sessions without a maximum lifetime are left untouched
+			if session.EndsOn > 0 {
+				now := time.Now().Unix()
+				if session.EndsOn < now {
+					session.EndsOn = now
+				}
+				session.EndsOn += extendRequest.seconds
+				session.ExpiresOn = session.getExpiresOn()
+				log.Printf("[SessionManager] -> Session lifetime extended by %d seconds | Session: %s", extendRequest.seconds, extendRequest.sessionHash)
+			}
+
+			state := *session
+			extendRequest.responseChan <- &state
 
-			//Check if the queue is empty
-			if len(s.containerPoolQueue) == 0 {
-				log.Printf("[SessionManager] -> No containers available")
-				s.requestQueue = append(s.requestQueue, &matchRequest)
+		case updateRequest := <-s.UpdateChan:
+			updateRequest.sessionHash = s.lookupHash(updateRequest.sessionHash)
+			session, ok := s.sessionMap[updateRequest.sessionHash]
+			if !ok {
+				updateRequest.responseChan <- nil
 				continue
 			}
 
-			//Get the first container
-			container := s.containerPoolQueue[0]
-			s.containerPoolQueue = s.containerPoolQueue[1:]
+			session.apply(updateRequest.options)
+			log.Printf("[SessionManager] -> Session updated | Session: %s", updateRequest.sessionHash)
 
-			//Add the container to the map
-			s.containerMap[container] = matchRequest.sessionHash
+			state := *session
+			updateRequest.responseChan <- &state
 
-			//Add the session to the map
-			s.sessionMap[matchRequest.sessionHash] = &SessionState{
-				SessionID: matchRequest.sessionID,
-				Addr:      container,
-				ExpiresOn: getExpiresOn(),
-				StartedOn: time.Now().Unix(),
+		case reserveRequest := <-s.ReserveChan:
+			for _, sessionID := range reserveRequest.sessionIDs {
+				s.reserve(sessionID)
 			}
+			s.saveReservations()
+			reserveRequest.responseChan <- s.getReservations()
 
-			log.Printf("[SessionManager] -> Container assigned to session | Session: %s | Container Addr: %s", matchRequest.sessionHash, container)
+		case releaseRequest := <-s.ReleaseChan:
+			released := s.release(s.lookupHash(releaseRequest.sessionHash))
+			if released {
+				s.saveReservations()
+			}
+			releaseRequest.responseChan <- released
 
-			matchRequest.responseChan <- container //Returns the url for the right container
+		case responseChan := <-s.GetReservationsChan:
+			responseChan <- s.getReservations()
 
-		case deleteRequest := <-s.DeleteChan:
-			sessionHash := deleteRequest.sessionHash
+		case responseChan := <-s.GetQueueChan:
+			responseChan <- s.getQueue()
 
-			found := false
-			//Check if there is a session assigned to the container
-			if session, ok := s.sessionMap[sessionHash]; ok {
-				// Remove the container from the maps
-				s.removeSession(sessionHash, session.Addr)
-				found = true
-			} else {
-				log.Printf("[SessionManager] -> Session not found | Session: %s", sessionHash)
+		case rotateRequest := <-s.RotateChan:
+			key := rotateRequest.key
+			if len(key) == 0 {
+				key = newKey()
 			}
+			s.rotateKey(key)
+			rotateRequest.responseChan <- keys.info()
 
-			deleteRequest.responseChan <- found
+		case resizeRequest := <-s.ResizeChan:
+			s.resizePool(resizeRequest.size)
+			resizeRequest.responseChan <- s.getPool()
+
+		case responseChan := <-s.GetPoolChan:
+			responseChan <- s.getPool()
+
+		case recycleRequest := <-s.RecycleInstanceChan:
+			recycleRequest.responseChan <- s.recycleInstance(recycleRequest.addr)
+
+		case responseChan := <-s.GetInstancesChan:
+			responseChan <- s.getInstanceStatus()
 
 		case responseChan := <-s.GetSessionsChan:
 			log.Printf("[SessionManager] -> Get sessions request received")
 
 			sessionMap := make(map[string]SessionState)
 			for sessionHash, session := range s.sessionMap {
-				sessionMap[sessionHash] = *session
+				state := *session
+				if team, ok := getTeam(session.SessionID); ok {
+					state.Team = team
+					state.Members = getTeamMemberHashes(team)
+				}
+				sessionMap[sessionHash] = state
 			}
 
 			responseChan <- sessionMap
@@ -144,30 +278,57 @@ func (s *SessionManagerService) run() {
 		case dockerReady := <-s.dockerReady:
 			log.Printf("[SessionManager] -> Docker ready event received | Container Addr: %s", dockerReady)
 
+			if s.shared {
+				s.addSharedInstance(dockerReady.(string))
+				continue
+			}
+
+			//Reservations are filled before the requests waiting in the queue
+			if s.fillReservation(dockerReady.(string)) {
+				continue
+			}
+
 			//Check if there are requests waiting
-			if len(s.requestQueue) > 0 {
-				//Get the first request
-				match := s.requestQueue[0]
-				s.requestQueue = s.requestQueue[1:]
+			assigned := false
+			for len(s.requestQueue) > 0 && !assigned {
+				//Get the request with the highest priority
+				match := s.dequeue()
+
+				//Another request of the same session already got a container
+				if _, ok := s.sessionMap[match.sessionHash]; ok {
+					s.match(match)
+					continue
+				}
 
 				//Add the container to the map
 				s.containerMap[dockerReady.(string)] = match.sessionHash
-				s.sessionMap[match.sessionHash] = &SessionState{
-					SessionID: match.sessionID,
-					Addr:      dockerReady.(string),
-					ExpiresOn: getExpiresOn(),
-					StartedOn: time.Now().Unix(),
-				}
+				session := s.newSession(match, dockerReady.(string))
+				s.addSession(match.sessionHash, session)
+				emit(EventAssigned, match.sessionHash, session)
 
 				//Send the response
-				match.responseChan <- dockerReady.(string) //Returns addr for the container
-			} else {
+				match.responseChan <- *session //Returns addr for the container
+				assigned = true
+			}
+
+			if !assigned {
 				//Add the container to the queue
 				s.containerPoolQueue = append(s.containerPoolQueue, dockerReady.(string))
 			}
 
+		case dockerResumed := <-s.dockerResumed:
+			log.Printf("[SessionManager] -> Docker resumed event received | Container Addr: %s", dockerResumed)
+			s.onResumed(dockerResumed.(string))
+
 		case dockerStop := <-s.dockerStop:
 			log.Printf("[SessionManager] -> Docker stop event received | Container Addr: %s", dockerStop)
+
+			if s.shared {
+				s.removeSharedInstance(dockerStop.(string), EventFailed)
+				s.scaleShared()
+				continue
+			}
+
 			//Remove the container from the queue
 			for i, container := range s.containerPoolQueue {
 				if container == dockerStop.(string) {
@@ -179,12 +340,21 @@ func (s *SessionManagerService) run() {
 
 			// Check if there is a session assigned to the container
 			if sessionHash, ok := s.containerMap[dockerStop.(string)]; ok {
+				session := s.sessionMap[sessionHash]
+
 				// Remove the container from the maps
 				s.removeSession(sessionHash, dockerStop.(string))
+				emit(EventFailed, sessionHash, session)
+			}
+
+			//The reservation gets a new warm container
+			if reservation, ok := s.isReserved(dockerStop.(string)); ok {
+				reservation.Addr = ""
+				reservation.requestContainer()
 			}
 
 			//Check if the pool size is enough
-			poolSize := config.GetInt(config.CReverseProxyPool)
+			poolSize := s.poolTarget
 			requiredContainers := poolSize - (len(s.containerPoolQueue) + len(s.requestQueue))
 			if requiredContainers > 0 {
 				log.Printf("[SessionManager] -> Requesting %d containers", requiredContainers)
@@ -195,7 +365,7 @@ func (s *SessionManagerService) run() {
 
 		case stateObj := <-s.dockerState:
 			state := stateObj.([]string)
-			poolSize := config.GetInt(config.CReverseProxyPool)
+			poolSize := s.poolTarget
 
 			//Initialize the pool
 			if !s.started {
@@ -218,14 +388,22 @@ func (s *SessionManagerService) run() {
 					for i := 0; i < requiredContainers; i++ {
 						cbroadcast.Broadcast(BSessionRequest, nil)
 					}
+					if s.shared {
+						s.sharedPool.pending += requiredContainers
+					}
 				}
 
 				//Add the containers to the pool
 				for i := 0; i < stateLength; i++ {
 					log.Printf("[SessionManager] -> Adding container to pool | Container Addr: %s", state[i])
-					s.containerPoolQueue = append(s.containerPoolQueue, state[i])
+					if s.shared {
+						s.sharedPool.add(state[i])
+					} else {
+						s.containerPoolQueue = append(s.containerPoolQueue, state[i])
+					}
 				}
 				s.started = true
+				s.requestReservations()
 				continue
 			}
 
@@ -234,7 +412,7 @@ func (s *SessionManagerService) run() {
 
 			for _, addr := range state {
 				inContainerMap := true
-				if _, ok := s.containerMap[addr]; !ok {
+				if _, ok := s.containerMap[addr]; !ok && !s.sharedPool.has(addr) {
 					inContainerMap = false
 				}
 
@@ -246,6 +424,10 @@ func (s *SessionManagerService) run() {
 					}
 				}
 
+				if _, ok := s.isReserved(addr); ok {
+					inPool = true
+				}
+
 				if !inContainerMap && !inPool {
 					if _, ok := s.containerRemovedMap[addr]; !ok {
 						log.Printf("[SessionManager] -> Container not in pool or session map | Container Addr: %s", addr)
@@ -273,19 +455,42 @@ func (s *SessionManagerService) run() {
 				}
 			}
 
+			for _, reservation := range s.reservations {
+				if _, ok := stateMap[reservation.Addr]; reservation.Addr != "" && !ok {
+					log.Printf("[SessionManager] -> Reserved container not in state | Container Addr: %s", reservation.Addr)
+					cbroadcast.Broadcast(bDockerStop, reservation.Addr)
+				}
+			}
+
+			for _, addr := range s.sharedPool.instances {
+				if _, ok := stateMap[addr]; !ok {
+					log.Printf("[SessionManager] -> Shared instance not in state | Container Addr: %s", addr)
+					cbroadcast.Broadcast(bDockerStop, addr)
+				}
+			}
+
 		case <-ticker.C:
 			//Check if there are sessions that have expired
 			for sessionHash, session := range s.sessionMap {
 				if session.ExpiresOn < time.Now().Unix() {
 					log.Printf("[SessionManager] -> Session expired | Session: %s", sessionHash)
+					s.stopSession(sessionHash, session.Addr)
+					emit(EventExpired, sessionHash, session)
+				}
+			}
+			for sessionHash, session := range s.recycled {
+				if session.ExpiresOn < time.Now().Unix() {
+					log.Printf("[SessionManager] -> Session expired while waiting for a new container | Session: %s", sessionHash)
+					s.forgetRecycled(sessionHash)
+					emit(EventExpired, sessionHash, session)
+				}
+			}
 
-					// Remove the container from the maps
-					s.removeSession(sessionHash, session.Addr)
+			s.suspendIdleSessions()
+			s.checkResuming()
 
-					//Send broadcast docker service to stop the container
-					cbroadcast.Broadcast(BSessionStop, session.Addr)
-					s.containerRemovedMap[session.Addr] = getExpiresOnMinute()
-				}
+			if s.shared && s.started {
+				s.scaleShared()
 			}
 
 			//Clean the containerRemovedMap
@@ -295,6 +500,8 @@ func (s *SessionManagerService) run() {
 				}
 			}
 		}
+
+		s.reportPool()
 	}
 }
 
@@ -302,6 +509,132 @@ func (s *SessionManagerService) subscribe() {
 	s.dockerReady, _ = cbroadcast.Subscribe(bDockerReady)
 	s.dockerStop, _ = cbroadcast.Subscribe(bDockerStop)
 	s.dockerState, _ = cbroadcast.Subscribe(bDockerState)
+	s.dockerResumed, _ = cbroadcast.Subscribe(bDockerResumed)
+}
+
+// match assigns a container to the session of the request. The request waits in a queue when no container is ready
+func (s *SessionManagerService) match(matchRequest *matchRequest) {
+	if session, ok := s.sessionMap[matchRequest.sessionHash]; ok {
+		if matchRequest.options != nil {
+			session.apply(matchRequest.options)
+		}
+		session.LastSeenOn = time.Now().Unix()
+		session.ExpiresOn = session.getExpiresOn()
+
+		//The response is sent once the container is resumed
+		if session.Suspended {
+			s.resumeSession(matchRequest, session)
+			return
+		}
+
+		matchRequest.responseChan <- *session
+		return
+	}
+
+	if s.shared {
+		cbroadcast.Broadcast(BSessionMetricStart, nil)
+		s.matchShared(matchRequest)
+		return
+	}
+
+	if s.matchReserved(matchRequest) {
+		return
+	}
+
+	//Request a new container
+	cbroadcast.Broadcast(BSessionRequest, nil)
+	cbroadcast.Broadcast(BSessionMetricStart, nil)
+
+	//Check if the queue is empty
+	if len(s.containerPoolQueue) == 0 {
+		log.Printf("[SessionManager] -> No containers available")
+		s.enqueue(matchRequest)
+		return
+	}
+
+	//Get the first container
+	container := s.containerPoolQueue[0]
+	s.containerPoolQueue = s.containerPoolQueue[1:]
+
+	//Add the container to the map
+	s.containerMap[container] = matchRequest.sessionHash
+
+	//Add the session to the map
+	session := s.newSession(matchRequest, container)
+	s.addSession(matchRequest.sessionHash, session)
+	emit(EventAssigned, matchRequest.sessionHash, session)
+
+	log.Printf("[SessionManager] -> Container assigned to session | Session: %s | Container Addr: %s", matchRequest.sessionHash, container)
+
+	matchRequest.responseChan <- *session //Returns the url for the right container
+}
+
+// newSession returns the state of the session on its new container. A recycled session keeps its state
+func (s *SessionManagerService) newSession(matchRequest *matchRequest, addr string) *SessionState {
+	session, ok := s.recycled[matchRequest.sessionHash]
+	if !ok {
+		return newSessionState(matchRequest.sessionID, addr, matchRequest.options)
+	}
+	delete(s.recycled, matchRequest.sessionHash)
+
+	session.Addr = addr
+	session.Suspended = false
+	session.LastSeenOn = time.Now().Unix()
+	if matchRequest.options != nil {
+		session.apply(matchRequest.options)
+	}
+	session.ExpiresOn = session.getExpiresOn()
+	return session
+}
+
+// recycleSession stops the container of the session. The session keeps its state and gets a new container on its next request
+func (s *SessionManagerService) recycleSession(sessionHash string, session *SessionState) {
+	addr := session.Addr
+	suspended := session.Suspended
+
+	//The session stays in the index so the proof of work is not asked again
+	delete(s.sessionMap, sessionHash)
+	delete(s.containerMap, addr)
+	s.recycled[sessionHash] = session
+
+	cbroadcast.Broadcast(BSessionStop, addr)
+	s.containerRemovedMap[addr] = getExpiresOnMinute()
+	emit(EventReset, sessionHash, session)
+
+	if suspended {
+		s.reportSuspended()
+	}
+
+	//Requests waiting for the container to be resumed get the new container
+	delete(s.resuming, addr)
+	if waiting, ok := s.resumeQueue[sessionHash]; ok {
+		delete(s.resumeQueue, sessionHash)
+		for _, matchRequest := range waiting {
+			s.match(matchRequest)
+		}
+	}
+}
+
+// forgetRecycled ends a recycled session that did not come back before its expiration
+func (s *SessionManagerService) forgetRecycled(sessionHash string) {
+	session := s.recycled[sessionHash]
+	delete(s.recycled, sessionHash)
+	index.remove(sessionHash)
+
+	cbroadcast.Broadcast(BSessionMetricTime, time.Now().Unix()-session.StartedOn)
+	if team, ok := getTeam(session.SessionID); ok {
+		releaseClaimedMembers(team)
+	}
+	s.endReservedSession(sessionHash)
+
+	log.Printf("[SessionManager] -> Recycled session removed | Session: %s", sessionHash)
+}
+
+// addSession adds the session to the map and to the index read by the reverse proxy
+func (s *SessionManagerService) addSession(sessionHash string, session *SessionState) {
+	s.sessionMap[sessionHash] = session
+	index.add(sessionHash)
+	usage.add(session.Addr, session.SessionID)
 }
 
 func (s *SessionManagerService) removeSession(sessionHash string, addr string) {
@@ -311,14 +644,61 @@ func (s *SessionManagerService) removeSession(sessionHash string, addr string) {
 	elapsed := time.Now().Unix() - startedOn
 	cbroadcast.Broadcast(BSessionMetricTime, elapsed)
 
+	suspended := s.sessionMap[sessionHash].Suspended
+	if team, ok := getTeam(s.sessionMap[sessionHash].SessionID); ok {
+		releaseClaimedMembers(team)
+	}
+
 	delete(s.sessionMap, sessionHash)
+	index.remove(sessionHash)
 	delete(s.containerMap, addr)
+	if s.shared {
+		s.releaseShared(addr)
+	}
+	s.endReservedSession(sessionHash)
 
 	log.Printf("[SessionManager] -> Session removed | Session: %s", sessionHash)
+
+	if suspended {
+		s.reportSuspended()
+	}
+
+	//Requests waiting for the container to be resumed get a new container
+	delete(s.resuming, addr)
+	if waiting, ok := s.resumeQueue[sessionHash]; ok {
+		delete(s.resumeQueue, sessionHash)
+		for _, matchRequest := range waiting {
+			s.match(matchRequest)
+		}
+	}
 }
 
-func getExpiresOn() int64 {
-	return time.Now().Unix() + config.GetInt64(config.CReverseProxySessionTimeout)
+// stopSession removes the session and sends the container to the docker service to be stopped
+func (s *SessionManagerService) stopSession(sessionHash string, addr string) {
+	// Remove the container from the maps
+	s.removeSession(sessionHash, addr)
+
+	//Shared instances are kept for the other sessions
+	if s.shared {
+		return
+	}
+
+	//Send broadcast docker service to stop the container
+	cbroadcast.Broadcast(BSessionStop, addr)
+	s.containerRemovedMap[addr] = getExpiresOnMinute()
+}
+
+// reportPool broadcasts the number of containers ready to be used when it changes
+func (s *SessionManagerService) reportPool() {
+	poolSize := len(s.containerPoolQueue)
+	if s.shared {
+		poolSize = len(s.sharedPool.instances)
+	}
+
+	if poolSize != s.poolSize {
+		s.poolSize = poolSize
+		cbroadcast.Broadcast(BSessionPool, poolSize)
+	}
 }
 
 func getExpiresOnMinute() int64 {
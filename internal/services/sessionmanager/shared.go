@@ -0,0 +1,211 @@
+package sessionmanager
+
+import (
+	"log"
+
+	"github.com/mart123p/ctf-reverseproxy/internal/config"
+	"github.com/mart123p/ctf-reverseproxy/pkg/cbroadcast"
+)
+
+// sharedPool keeps track of the instances shared by many sessions
+type sharedPool struct {
+	instances []string       //Ordered instances used by the round-robin strategy
+	sessions  map[string]int //Instance addr -> number of sessions
+	next      int            //Next instance used by the round-robin strategy
+	pending   int            //Instances requested to the docker service that are not ready yet
+}
+
+func newSharedPool() sharedPool {
+	return sharedPool{
+		instances: make([]string, 0),
+		sessions:  make(map[string]int),
+	}
+}
+
+func (p *sharedPool) has(addr string) bool {
+	_, ok := p.sessions[addr]
+	return ok
+}
+
+// pick returns the instance assigned to a new session. Returns an empty string if there are no instances
+func (p *sharedPool) pick() string {
+	if len(p.instances) == 0 {
+		return ""
+	}
+
+	//Instances are overcommitted when all of them are full
+	limit := config.GetInt(config.CReverseProxySharedSessionsPerInstance)
+	full := true
+	for _, addr := range p.instances {
+		if p.sessions[addr] < limit {
+			full = false
+			break
+		}
+	}
+
+	available := func(addr string) bool {
+		return full || p.sessions[addr] < limit
+	}
+
+	if config.GetString(config.CReverseProxySharedStrategy) == "round-robin" {
+		for i := 0; i < len(p.instances); i++ {
+			addr := p.instances[(p.next+i)%len(p.instances)]
+			if available(addr) {
+				p.next = (p.next + i + 1) % len(p.instances)
+				return addr
+			}
+		}
+	}
+
+	//least-sessions
+	selected := p.instances[0]
+	for _, addr := range p.instances[1:] {
+		if p.sessions[addr] < p.sessions[selected] {
+			selected = addr
+		}
+	}
+	return selected
+}
+
+func (p *sharedPool) add(addr string) {
+	if p.has(addr) {
+		return
+	}
+	p.instances = append(p.instances, addr)
+	p.sessions[addr] = 0
+}
+
+func (p *sharedPool) remove(addr string) {
+	if !p.has(addr) {
+		return
+	}
+
+	delete(p.sessions, addr)
+	for i, instance := range p.instances {
+		if instance == addr {
+			p.instances = append(p.instances[:i], p.instances[i+1:]...)
+			break
+		}
+	}
+	if p.next >= len(p.instances) {
+		p.next = 0
+	}
+}
+
+// matchShared assigns a shared instance to a new session
+func (s *SessionManagerService) matchShared(matchRequest *matchRequest) {
+	container := s.sharedPool.pick()
+	if container == "" {
+		log.Printf("[SessionManager] -> No shared instances available")
+		s.enqueue(matchRequest)
+		return
+	}
+
+	s.sharedPool.sessions[container]++
+
+	session := s.newSession(matchRequest, container)
+	s.addSession(matchRequest.sessionHash, session)
+	emit(EventAssigned, matchRequest.sessionHash, session)
+
+	log.Printf("[SessionManager] -> Shared instance assigned to session | Session: %s | Container Addr: %s | Sessions: %d", matchRequest.sessionHash, container, s.sharedPool.sessions[container])
+
+	matchRequest.responseChan <- *session
+
+	s.scaleShared()
+}
+
+// addSharedInstance adds a ready instance to the pool and answers the waiting requests
+func (s *SessionManagerService) addSharedInstance(addr string) {
+	if s.sharedPool.pending > 0 {
+		s.sharedPool.pending--
+	}
+	s.sharedPool.add(addr)
+	log.Printf("[SessionManager] -> Shared instance ready | Container Addr: %s | Instances: %d", addr, len(s.sharedPool.instances))
+
+	waiting := make([]*matchRequest, 0, len(s.requestQueue))
+	for len(s.requestQueue) > 0 {
+		waiting = append(waiting, s.dequeue())
+	}
+	for _, matchRequest := range waiting {
+		//The session could have been created by another request in the queue
+		if _, ok := s.sessionMap[matchRequest.sessionHash]; ok {
+			s.match(matchRequest)
+		} else {
+			s.matchShared(matchRequest)
+		}
+	}
+}
+
+// removeSharedInstance removes an instance that is no longer present. Its sessions get another instance on their next request
+func (s *SessionManagerService) removeSharedInstance(addr string, eventType string) {
+	if !s.sharedPool.has(addr) {
+		return
+	}
+
+	for sessionHash, session := range s.sessionMap {
+		if session.Addr == addr {
+			s.removeSession(sessionHash, addr)
+			emit(eventType, sessionHash, session)
+		}
+	}
+	s.sharedPool.remove(addr)
+	log.Printf("[SessionManager] -> Shared instance removed | Container Addr: %s | Instances: %d", addr, len(s.sharedPool.instances))
+}
+
+// stopSharedInstance removes the instance and sends it to the docker service to be stopped
+func (s *SessionManagerService) stopSharedInstance(addr string, eventType string) {
+	s.removeSharedInstance(addr, eventType)
+
+	cbroadcast.Broadcast(BSessionStop, addr)
+	s.containerRemovedMap[addr] = getExpiresOnMinute()
+}
+
+// releaseShared is called when a session using a shared instance is removed
+func (s *SessionManagerService) releaseShared(addr string) {
+	if count, ok := s.sharedPool.sessions[addr]; ok && count > 0 {
+		s.sharedPool.sessions[addr] = count - 1
+	}
+}
+
+// scaleShared adjusts the number of shared instances to the number of sessions
+func (s *SessionManagerService) scaleShared() {
+	//The initial instances are requested once the state of docker is known
+	if !s.started {
+		return
+	}
+
+	desired := s.poolTarget
+
+	maxInstances := config.GetInt(config.CReverseProxySharedMaxInstances)
+	if maxInstances > desired {
+		perInstance := config.GetInt(config.CReverseProxySharedSessionsPerInstance)
+		required := (len(s.sessionMap) + perInstance - 1) / perInstance
+		if required > maxInstances {
+			required = maxInstances
+		}
+		if required > desired {
+			desired = required
+		}
+	}
+
+	current := len(s.sharedPool.instances) + s.sharedPool.pending
+	if current < desired {
+		log.Printf("[SessionManager] -> Requesting %d shared instances", desired-current)
+		for i := current; i < desired; i++ {
+			cbroadcast.Broadcast(BSessionRequest, nil)
+			s.sharedPool.pending++
+		}
+		return
+	}
+
+	//Only the empty instances are removed when the load goes down
+	excess := len(s.sharedPool.instances) - desired
+	for i := len(s.sharedPool.instances) - 1; i >= 0 && excess > 0; i-- {
+		addr := s.sharedPool.instances[i]
+		if s.sharedPool.sessions[addr] == 0 {
+			log.Printf("[SessionManager] -> Removing idle shared instance | Container Addr: %s", addr)
+			s.stopSharedInstance(addr, EventExpired)
+			excess--
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package sessionmanager
+
+import (
+	"time"
+
+	"github.com/mart123p/ctf-reverseproxy/internal/config"
+)
+
+// SessionMetadata is set by the operators through the management API
+type SessionMetadata struct {
+	TeamName string
+	Notes    string
+}
+
+// SessionOptions overrides the config for a single session. A nil field is left unchanged
+type SessionOptions struct {
+	Timeout     *int64 //Idle timeout in seconds. 0 uses the config
+	MaxLifetime *int64 //Maximum lifetime in seconds. 0 uses the config
+	TeamName    *string
+	Notes       *string
+}
+
+func newSessionState(sessionID string, addr string, options *SessionOptions) *SessionState {
+	now := time.Now().Unix()
+	session := &SessionState{
+		SessionID:  sessionID,
+		Addr:       addr,
+		StartedOn:  now,
+		LastSeenOn: now,
+	}
+
+	if options != nil {
+		session.apply(options)
+	} else {
+		session.setEndsOn()
+		session.ExpiresOn = session.getExpiresOn()
+	}
+	return session
+}
+
+// apply the options to the session
+func (session *SessionState) apply(options *SessionOptions) {
+	previousLifetime := session.getMaxLifetime()
+
+	if options.Timeout != nil {
+		session.Timeout = *options.Timeout
+	}
+
+	if options.MaxLifetime != nil {
+		session.MaxLifetime = *options.MaxLifetime
+	}
+
+	if options.TeamName != nil || options.Notes != nil {
+		if session.Metadata == nil {
+			session.Metadata = &SessionMetadata{}
+		}
+		if options.TeamName != nil {
+			session.Metadata.TeamName = *options.TeamName
+		}
+		if options.Notes != nil {
+			session.Metadata.Notes = *options.Notes
+		}
+	}
+
+	//The time added by an extension is kept when the maximum lifetime changes
+	if options.MaxLifetime != nil && session.EndsOn > 0 && session.getMaxLifetime() > 0 {
+		session.EndsOn += session.getMaxLifetime() - previousLifetime
+	} else if options.MaxLifetime != nil || session.EndsOn == 0 {
+		session.setEndsOn()
+	}
+
+	//Editing the metadata is not an activity of the player
+	if options.Timeout != nil || options.MaxLifetime != nil || session.ExpiresOn == 0 {
+		session.ExpiresOn = session.getExpiresOn()
+	}
+}
+
+// getMaxLifetime returns the maximum lifetime of the session in seconds. 0 when it is unlimited
+func (session *SessionState) getMaxLifetime() int64 {
+	if session.MaxLifetime != 0 {
+		return session.MaxLifetime
+	}
+	return config.GetInt64(config.CReverseProxySessionMaxLifetime)
+}
+
+// setEndsOn computes the hard limit of the session from its start
+func (session *SessionState) setEndsOn() {
+	maxLifetime := session.getMaxLifetime()
+
+	session.EndsOn = 0
+	if maxLifetime > 0 {
+		session.EndsOn = session.StartedOn + maxLifetime
+	}
+}
+
+// getExpiresOn returns the idle expiration of the session from its last activity. It never goes past the maximum lifetime
+func (session *SessionState) getExpiresOn() int64 {
+	timeout := session.Timeout
+	if timeout == 0 {
+		timeout = config.GetInt64(config.CReverseProxySessionTimeout)
+	}
+
+	expiresOn := session.LastSeenOn + timeout
+	if session.EndsOn > 0 && session.EndsOn < expiresOn {
+		return session.EndsOn
+	}
+	return expiresOn
+}
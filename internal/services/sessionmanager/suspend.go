@@ -0,0 +1,107 @@
+package sessionmanager
+
+import (
+	"log"
+	"time"
+
+	"github.com/mart123p/ctf-reverseproxy/internal/config"
+	"github.com/mart123p/ctf-reverseproxy/pkg/cbroadcast"
+)
+
+// suspendIdleSessions suspends the containers of the sessions that are idle for longer than the suspend delay
+func (s *SessionManagerService) suspendIdleSessions() {
+	//Shared instances are used by other sessions
+	suspendAfter := config.GetInt64(config.CReverseProxySessionSuspend)
+	if suspendAfter <= 0 || s.shared {
+		return
+	}
+
+	now := time.Now().Unix()
+	changed := false
+	for sessionHash, session := range s.sessionMap {
+		if session.Suspended || now-session.LastSeenOn < suspendAfter {
+			continue
+		}
+
+		log.Printf("[SessionManager] -> Session idle, suspending the container | Session: %s | Container Addr: %s", sessionHash, session.Addr)
+		session.Suspended = true
+		cbroadcast.Broadcast(BSessionSuspend, session.Addr)
+		emit(EventSuspended, sessionHash, session)
+		changed = true
+	}
+
+	if changed {
+		s.reportSuspended()
+	}
+}
+
+// The resume is broadcast again after resumeRetry since the broadcasts can be dropped.
+// The session gets a new container when its container is not resumed after resumeTimeout
+const (
+	resumeRetry   = 10 * time.Second
+	resumeTimeout = 30 * time.Second
+)
+
+// resumeSession queues the request until the container of the session is resumed
+func (s *SessionManagerService) resumeSession(matchRequest *matchRequest, session *SessionState) {
+	waiting := s.resumeQueue[matchRequest.sessionHash]
+	s.resumeQueue[matchRequest.sessionHash] = append(waiting, matchRequest)
+
+	//Only the first request asks for the container to be resumed
+	if len(waiting) == 0 {
+		log.Printf("[SessionManager] -> Resuming the container | Session: %s | Container Addr: %s", matchRequest.sessionHash, session.Addr)
+		s.resuming[session.Addr] = time.Now()
+		cbroadcast.Broadcast(BSessionResume, session.Addr)
+	}
+}
+
+// checkResuming asks again for the containers that are not resumed yet. The containers that take too long are recycled
+// and the waiting requests get a new container
+func (s *SessionManagerService) checkResuming() {
+	now := time.Now()
+	for sessionHash := range s.resumeQueue {
+		session, ok := s.sessionMap[sessionHash]
+		if !ok {
+			continue
+		}
+
+		elapsed := now.Sub(s.resuming[session.Addr])
+		if elapsed >= resumeTimeout {
+			log.Printf("Warning: [SessionManager] -> Container not resumed in time, recycling | Session: %s | Container Addr: %s", sessionHash, session.Addr)
+			s.recycleSession(sessionHash, session)
+		} else if elapsed >= resumeRetry {
+			log.Printf("[SessionManager] -> Resuming the container again | Session: %s | Container Addr: %s", sessionHash, session.Addr)
+			cbroadcast.Broadcast(BSessionResume, session.Addr)
+		}
+	}
+}
+
+// onResumed answers the requests that were waiting for the container
+func (s *SessionManagerService) onResumed(addr string) {
+	sessionHash, ok := s.containerMap[addr]
+	if !ok {
+		return
+	}
+
+	delete(s.resuming, addr)
+
+	session := s.sessionMap[sessionHash]
+	session.Suspended = false
+	s.reportSuspended()
+	emit(EventResumed, sessionHash, session)
+
+	for _, matchRequest := range s.resumeQueue[sessionHash] {
+		matchRequest.responseChan <- *session
+	}
+	delete(s.resumeQueue, sessionHash)
+}
+
+func (s *SessionManagerService) reportSuspended() {
+	suspended := 0
+	for _, session := range s.sessionMap {
+		if session.Suspended {
+			suspended++
+		}
+	}
+	cbroadcast.Broadcast(BSessionMetricSuspended, suspended)
+}
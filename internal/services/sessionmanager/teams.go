@@ -0,0 +1,175 @@
+package sessionmanager
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/mart123p/ctf-reverseproxy/internal/config"
+)
+
+// Prefix of the session id shared by the members of a team
+const teamPrefix = "team:"
+const escapePrefix = "member:"
+
+// teamRegistry maps the session id of a member to its team
+type teamRegistry struct {
+	mu      sync.RWMutex
+	members map[string]string //Member session id -> team
+	claimed map[string]bool   //Members added from the session tokens. Removed when the session of their team ends
+}
+
+var teams = teamRegistry{
+	members: make(map[string]string),
+	claimed: make(map[string]bool),
+}
+
+// loadTeams reads the team file. The file is a json object of the team names with the list of the member session ids
+func loadTeams() {
+	path := config.GetString(config.CReverseProxySessionTeamsFile)
+	if path == "" {
+		return
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("[SessionManager] -> Could not read the teams file \"%s\", %s", path, err)
+	}
+
+	var fileTeams map[string][]string
+	if err := json.Unmarshal(data, &fileTeams); err != nil {
+		log.Fatalf("[SessionManager] -> Could not parse the teams file \"%s\", %s", path, err)
+	}
+
+	for team, members := range fileTeams {
+		SetTeam(team, members)
+	}
+	log.Printf("[SessionManager] -> %d teams loaded from \"%s\"", len(fileTeams), path)
+}
+
+// Resolve returns the session id used for the sessionID. Members of a team share the session id of the team
+func Resolve(sessionID string) string {
+	teams.mu.RLock()
+	defer teams.mu.RUnlock()
+
+	if team, ok := teams.members[sessionID]; ok {
+		return teamPrefix + team
+	}
+
+	//A player could send the session id of a team directly. It is escaped so that it never matches the session of the team
+	if strings.HasPrefix(sessionID, teamPrefix) {
+		return escapePrefix + sessionID
+	}
+	return sessionID
+}
+
+// ResolveOperator returns the session id used for a sessionID given by the management api. A team:<name> id addresses the session of the team
+func ResolveOperator(sessionID string) string {
+	if strings.HasPrefix(sessionID, teamPrefix) {
+		return sessionID
+	}
+	return Resolve(sessionID)
+}
+
+// AddTeamMember adds the member to the team. Used when the team is known from the session token. Only a new member takes the write lock
+func AddTeamMember(team string, member string) {
+	teams.mu.RLock()
+	current, ok := teams.members[member]
+	teams.mu.RUnlock()
+
+	if ok && current == team {
+		return
+	}
+
+	teams.mu.Lock()
+	teams.members[member] = team
+	teams.claimed[member] = true
+	teams.mu.Unlock()
+}
+
+// releaseClaimedMembers removes the members of the team added from the session tokens. They are added again by their next request
+func releaseClaimedMembers(team string) {
+	teams.mu.Lock()
+	defer teams.mu.Unlock()
+
+	for member := range teams.claimed {
+		if teams.members[member] == team {
+			delete(teams.members, member)
+			delete(teams.claimed, member)
+		}
+	}
+}
+
+// SetTeam replaces the members of the team
+func SetTeam(team string, members []string) {
+	teams.mu.Lock()
+	defer teams.mu.Unlock()
+
+	for member, memberTeam := range teams.members {
+		if memberTeam == team {
+			delete(teams.members, member)
+			delete(teams.claimed, member)
+		}
+	}
+	for _, member := range members {
+		teams.members[member] = team
+		delete(teams.claimed, member)
+	}
+}
+
+// DeleteTeam removes all the members of the team. Returns false if the team has no members
+func DeleteTeam(team string) bool {
+	teams.mu.Lock()
+	defer teams.mu.Unlock()
+
+	found := false
+	for member, memberTeam := range teams.members {
+		if memberTeam == team {
+			delete(teams.members, member)
+			delete(teams.claimed, member)
+			found = true
+		}
+	}
+	return found
+}
+
+// GetTeams returns the members of every team
+func GetTeams() map[string][]string {
+	teams.mu.RLock()
+	defer teams.mu.RUnlock()
+
+	result := make(map[string][]string)
+	for member, team := range teams.members {
+		result[team] = append(result[team], member)
+	}
+	for _, members := range result {
+		sort.Strings(members)
+	}
+	return result
+}
+
+// getTeam returns the team of a session id resolved by Resolve
+func getTeam(sessionID string) (string, bool) {
+	if !strings.HasPrefix(sessionID, teamPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(sessionID, teamPrefix), true
+}
+
+// getTeamMemberHashes returns the hashes of the known members of the team. The session ids of the members are never exposed
+func getTeamMemberHashes(team string) []string {
+	teams.mu.RLock()
+	defer teams.mu.RUnlock()
+
+	members := make([]string, 0)
+	for member, memberTeam := range teams.members {
+		if memberTeam == team {
+			members = append(members, GetHash(member))
+		}
+	}
+	sort.Strings(members)
+	return members
+}
@@ -0,0 +1,136 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mart123p/ctf-reverseproxy/internal/config"
+)
+
+const signatureHeader = "X-Webhook-Signature" //sha256=<hex hmac of the timestamp, a dot and the body>
+const timestampHeader = "X-Webhook-Timestamp" //Unix time of the attempt. Part of the signed material
+const idHeader = "X-Webhook-Id"               //Same for every attempt of a delivery so the receivers can drop the duplicates
+const eventHeader = "X-Webhook-Event"
+
+// Delay before the first retry. It doubles after every failed attempt
+const retryDelay = time.Second
+
+type delivery struct {
+	id    string
+	event string
+	body  []byte
+}
+
+// newDeliveryId returns a random identifier for a delivery
+func newDeliveryId() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// endpoint delivers the payloads to one url. A slow url does not delay the others
+type endpoint struct {
+	url      string
+	secret   []byte
+	retries  int
+	client   *http.Client
+	pending  chan delivery
+	shutdown chan bool
+}
+
+func newEndpoint(url string, shutdown chan bool) *endpoint {
+	return &endpoint{
+		url:     url,
+		secret:  []byte(config.GetString(config.CWebhookSecret)),
+		retries: config.GetInt(config.CWebhookRetries),
+		client: &http.Client{
+			Timeout: time.Duration(config.GetInt64(config.CWebhookTimeout)) * time.Second,
+		},
+		pending:  make(chan delivery, config.GetInt(config.CWebhookQueue)),
+		shutdown: shutdown,
+	}
+}
+
+// queue adds the payload to the queue. The payload is dropped when the queue is full
+func (e *endpoint) queue(event string, body []byte) {
+	select {
+	case e.pending <- delivery{id: newDeliveryId(), event: event, body: body}:
+	default:
+		log.Printf("Warning: [Webhook] -> Queue full, payload dropped | Url: %s | Event: %s", e.url, event)
+	}
+}
+
+func (e *endpoint) run() {
+	for {
+		select {
+		case <-e.shutdown:
+			return
+		case d := <-e.pending:
+			e.deliver(d)
+		}
+	}
+}
+
+// deliver posts the payload until it is accepted or the retries are exhausted
+func (e *endpoint) deliver(d delivery) {
+	delay := retryDelay
+	for attempt := 0; ; attempt++ {
+		err := e.post(d)
+		if err == nil {
+			return
+		}
+
+		if attempt >= e.retries {
+			log.Printf("Warning: [Webhook] -> Delivery failed, payload dropped | Url: %s | Event: %s | Error: %s", e.url, d.event, err)
+			return
+		}
+
+		select {
+		case <-e.shutdown:
+			return
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+func (e *endpoint) post(d delivery) error {
+	req, err := http.NewRequest(http.MethodPost, e.url, bytes.NewReader(d.body))
+	if err != nil {
+		return err
+	}
+
+	//The timestamp is signed so a captured delivery cannot be replayed outside the tolerance of the receiver
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, e.secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(d.body)
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ctf-reverseproxy")
+	req.Header.Set(eventHeader, d.event)
+	req.Header.Set(idHeader, d.id)
+	req.Header.Set(timestampHeader, timestamp)
+	req.Header.Set(signatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package webhook
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/mart123p/ctf-reverseproxy/internal/config"
+	service "github.com/mart123p/ctf-reverseproxy/internal/services"
+	"github.com/mart123p/ctf-reverseproxy/internal/services/docker"
+	"github.com/mart123p/ctf-reverseproxy/internal/services/sessionmanager"
+	"github.com/mart123p/ctf-reverseproxy/pkg/cbroadcast"
+)
+
+// Payload posted to the webhook urls
+type Payload struct {
+	Event   string
+	Time    int64
+	Addr    string                       `json:",omitempty"` //Container addr of the docker and session topics
+	Session *sessionmanager.SessionEvent `json:",omitempty"` //Session lifecycle events
+}
+
+type WebhookService struct {
+	shutdown chan bool
+
+	sessionRequest cbroadcast.Channel
+	sessionStop    cbroadcast.Channel
+	sessionEvent   cbroadcast.Channel
+	dockerReady    cbroadcast.Channel
+	dockerStop     cbroadcast.Channel
+
+	endpoints []*endpoint
+	events    map[string]bool //Events sent to the urls. Empty to send all the events
+	sessionId bool
+
+	wg sync.WaitGroup
+}
+
+func (w *WebhookService) Register() {
+
+}
+
+func (w *WebhookService) Init() {
+	w.shutdown = make(chan bool)
+
+	w.events = make(map[string]bool)
+	for _, event := range config.GetStringSlice(config.CWebhookEvents) {
+		w.events[event] = true
+	}
+	w.sessionId = config.GetBool(config.CWebhookSessionId)
+
+	for _, url := range config.GetStringSlice(config.CWebhookUrls) {
+		w.endpoints = append(w.endpoints, newEndpoint(url, w.shutdown))
+	}
+
+	//The topics are only consumed when there is a webhook
+	if len(w.endpoints) > 0 {
+		w.subscribe()
+	}
+}
+
+// Start the webhook service
+func (w *WebhookService) Start() {
+	log.Printf("[Webhook] -> Starting webhook service | Urls: %d", len(w.endpoints))
+
+	for _, e := range w.endpoints {
+		w.wg.Add(1)
+		go func(e *endpoint) {
+			defer w.wg.Done()
+			e.run()
+		}(e)
+	}
+
+	go w.run()
+}
+
+// Shutdown the webhook service
+func (w *WebhookService) Shutdown() {
+	log.Printf("[Webhook] -> Stopping webhook service")
+	close(w.shutdown)
+}
+
+func (w *WebhookService) run() {
+	defer service.Closed()
+
+	for {
+		select {
+		case <-w.shutdown:
+			w.wg.Wait()
+			log.Printf("[Webhook] -> Webhook service closed")
+			return
+
+		case <-w.sessionRequest:
+			w.send(Payload{Event: sessionmanager.BSessionRequest})
+
+		case addr := <-w.sessionStop:
+			w.send(Payload{Event: sessionmanager.BSessionStop, Addr: addr.(string)})
+
+		case addr := <-w.dockerReady:
+			w.send(Payload{Event: docker.BDockerReady, Addr: addr.(string)})
+
+		case addr := <-w.dockerStop:
+			w.send(Payload{Event: docker.BDockerStop, Addr: addr.(string)})
+
+		case eventObj := <-w.sessionEvent:
+			event := eventObj.(sessionmanager.SessionEvent)
+			if !w.sessionId {
+				event.SessionID = ""
+			}
+			w.send(Payload{Event: "session:" + event.Type, Addr: event.Addr, Session: &event})
+		}
+	}
+}
+
+// send queues the payload for every url. Never blocks
+func (w *WebhookService) send(payload Payload) {
+	if len(w.events) > 0 && !w.events[payload.Event] {
+		return
+	}
+
+	payload.Time = time.Now().Unix()
+	if payload.Session != nil {
+		payload.Time = payload.Session.Time
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Warning: [Webhook] -> Could not encode the payload of %s, %s", payload.Event, err)
+		return
+	}
+
+	for _, endpoint := range w.endpoints {
+		endpoint.queue(payload.Event, body)
+	}
+}
+
+func (w *WebhookService) subscribe() {
+	w.sessionRequest, _ = cbroadcast.Subscribe(sessionmanager.BSessionRequest)
+	w.sessionStop, _ = cbroadcast.Subscribe(sessionmanager.BSessionStop)
+	w.sessionEvent, _ = cbroadcast.Subscribe(sessionmanager.BSessionEvent)
+	w.dockerReady, _ = cbroadcast.Subscribe(docker.BDockerReady)
+	w.dockerStop, _ = cbroadcast.Subscribe(docker.BDockerStop)
+}
@@ -0,0 +1,11 @@
+package filename
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// Valid checks that a name given by a client is a single path element
+func Valid(name string) bool {
+	return name != "" && name != "." && name != ".." && filepath.Base(name) == name && !strings.ContainsAny(name, `/\`)
+}
@@ -0,0 +1,164 @@
+package rotate
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Writer is an append only file that is rotated once it reaches a maximum size.
+// Rotated files are renamed with a numeric suffix (file.1 being the most recent)
+type Writer struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	file   *os.File
+	size   int64
+	closed bool
+}
+
+// Open the file at path. A maxSize of 0 disables the rotation and maxBackups is the number of rotated files kept.
+// A negative maxBackups keeps every rotated file
+func Open(path string, maxSize int64, maxBackups int) (*Writer, error) {
+	w := &Writer{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Write p to the file. Each call is written atomically so that a line is never split between two files
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return 0, os.ErrClosed
+	}
+
+	//The file could not be reopened after a rotation
+	if w.file == nil {
+		if err := w.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	//A failed rotation is reported but the data is still written to the reopened file
+	var rotateErr error
+	if w.maxSize > 0 && w.size > 0 && w.size+int64(len(p)) > w.maxSize {
+		rotateErr = w.rotate()
+		if w.file == nil {
+			return 0, rotateErr
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	if err == nil {
+		err = rotateErr
+	}
+	return n, err
+}
+
+// Close the underlying file
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.closed = true
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+// Files returns the path of the rotated files that exist followed by the current file. The oldest file is first
+func (w *Writer) Files() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	files := make([]string, 0)
+	for i := w.backups(); i > 0; i-- {
+		name := backupName(w.path, i)
+		if _, err := os.Stat(name); err == nil {
+			files = append(files, name)
+		}
+	}
+	return append(files, w.path)
+}
+
+func (w *Writer) open() error {
+	file, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	w.file = file
+	w.size = info.Size()
+	return nil
+}
+
+func (w *Writer) rotate() error {
+	w.file.Close()
+	w.file = nil
+
+	var err error
+	if w.maxBackups != 0 {
+		//Shift the backups file.1 -> file.2 and so on. The oldest one is overwritten unless every file is kept
+		last := w.maxBackups - 1
+		if w.maxBackups < 0 {
+			last = w.backups()
+		}
+		for i := last; i > 0; i-- {
+			_ = os.Rename(backupName(w.path, i), backupName(w.path, i+1))
+		}
+		err = os.Rename(w.path, backupName(w.path, 1))
+	} else {
+		err = os.Remove(w.path)
+	}
+
+	//The path is always reopened so a failed rotation does not close the writer for good
+	if openErr := w.open(); openErr != nil {
+		return openErr
+	}
+	return err
+}
+
+// backups returns the number of rotated files that can exist. Every file is looked up when they are all kept
+func (w *Writer) backups() int {
+	if w.maxBackups >= 0 {
+		return w.maxBackups
+	}
+
+	count := 0
+	for {
+		if _, err := os.Stat(backupName(w.path, count+1)); err != nil {
+			return count
+		}
+		count++
+	}
+}
+
+func backupName(path string, index int) string {
+	return fmt.Sprintf("%s.%d", path, index)
+}